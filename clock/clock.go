@@ -0,0 +1,50 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	mutex      sync.Mutex
+	start      = time.Now()
+	simulating bool
+	simulated  time.Time
+	tickStep   time.Duration
+)
+
+//Now returns the time used in generated flows, time.Now unless simulated
+func Now() time.Time {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if simulating {
+		return simulated
+	}
+	return time.Now()
+}
+
+//Start returns the start time of the exporters, the origin of the system
+//uptimes of the generated flows
+func Start() time.Time {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return start
+}
+
+//Simulate replaces Now by a clock starting at from and moving forward
+//by step on each Tick, so that generated timestamps are reproducible
+func Simulate(from time.Time, step time.Duration) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	start = from
+	simulated = from
+	simulating = true
+	tickStep = step
+}
+
+//Tick moves the simulated clock forward, it has no effect on the real clock
+func Tick() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	simulated = simulated.Add(tickStep)
+}
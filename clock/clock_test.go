@@ -0,0 +1,26 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	if now := Now(); time.Since(now) > time.Minute {
+		t.Fatalf("real clock at %s", now)
+	}
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	Simulate(from, time.Second)
+	if !Now().Equal(from) || !Start().Equal(from) {
+		t.Errorf("simulated clock at %s started at %s, want %s", Now(), Start(), from)
+	}
+	Tick()
+	Tick()
+	if want := from.Add(2 * time.Second); !Now().Equal(want) {
+		t.Errorf("simulated clock at %s after 2 ticks, want %s", Now(), want)
+	}
+	if !Start().Equal(from) {
+		t.Errorf("start moved to %s", Start())
+	}
+}
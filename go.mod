@@ -5,7 +5,10 @@ go 1.16
 require (
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/netobserv/netobserv-ebpf-agent v0.1.3
+	github.com/prometheus/client_golang v1.12.2
 	github.com/seancfoley/ipaddress-go v1.2.0
+	github.com/segmentio/kafka-go v0.4.35
 	github.com/sirupsen/logrus v1.8.1
 	google.golang.org/protobuf v1.28.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,246 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrShortMessage = errors.New("ipfix message too short")
+
+//templates are scoped by observation domain - RFC7011 section 8
+type templateKey struct {
+	domainID   uint32
+	templateID uint16
+}
+
+//Decoder parses ipfix messages of a transport session, keeping the templates
+//announced by each observation domain to decode the following data sets
+type Decoder struct {
+	templates map[templateKey][]FieldSpecifier
+	Missing   uint64 // data sets dropped because their template is unknown
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{
+		templates: map[templateKey][]FieldSpecifier{},
+	}
+}
+
+//Decode an ipfix packet byte array to a Message. Templates are learned on
+//the way and data sets of unknown templates are skipped.
+func (d *Decoder) Decode(b []byte) (*Message, error) {
+	if len(b) < 16 {
+		return nil, ErrShortMessage
+	}
+	msg := &Message{
+		Header: MessageHeader{
+			Version:    binary.BigEndian.Uint16(b[0:]),
+			Length:     binary.BigEndian.Uint16(b[2:]),
+			ExportTime: binary.BigEndian.Uint32(b[4:]),
+			SequenceNo: binary.BigEndian.Uint32(b[8:]),
+			DomainID:   binary.BigEndian.Uint32(b[12:]),
+		},
+	}
+	if msg.Header.Version != VERSION {
+		return nil, fmt.Errorf("ipfix version %d is not valid", msg.Header.Version)
+	}
+	if int(msg.Header.Length) > len(b) || msg.Header.Length < 16 {
+		return nil, ErrShortMessage
+	}
+
+	b = b[16:msg.Header.Length]
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return msg, ErrShortMessage
+		}
+		header := SetHeader{
+			ID:     binary.BigEndian.Uint16(b[0:]),
+			Length: binary.BigEndian.Uint16(b[2:]),
+		}
+		if header.Length < 4 || int(header.Length) > len(b) {
+			return msg, fmt.Errorf("set %d length %d is not valid", header.ID, header.Length)
+		}
+		body := b[4:header.Length]
+		b = b[header.Length:]
+
+		var err error
+		switch {
+		case header.ID == TEMPLATE_SET_ID:
+			err = d.decodeTemplateSet(msg, header, body)
+		case header.ID == OPTIONS_TEMPLATE_SET_ID:
+			err = d.decodeOptionsTemplateSet(msg, header, body)
+		case header.ID >= MIN_DATA_SET_ID:
+			err = d.decodeDataSet(msg, header, body)
+		}
+		if err != nil {
+			return msg, err
+		}
+	}
+	return msg, nil
+}
+
+func (d *Decoder) decodeTemplateSet(msg *Message, header SetHeader, b []byte) error {
+	set := TemplateSet{Header: header}
+	//the remaining bytes of a set smaller than a template header are padding
+	for len(b) >= 4 {
+		tpl := TemplateRecord{
+			ID:         binary.BigEndian.Uint16(b[0:]),
+			FieldCount: binary.BigEndian.Uint16(b[2:]),
+		}
+		var err error
+		tpl.Fields, b, err = decodeFields(b[4:], int(tpl.FieldCount))
+		if err != nil {
+			return err
+		}
+		d.learn(msg.Header.DomainID, tpl.ID, tpl.Fields)
+		set.Templates = append(set.Templates, tpl)
+	}
+	msg.TemplateSet = append(msg.TemplateSet, set)
+	return nil
+}
+
+func (d *Decoder) decodeOptionsTemplateSet(msg *Message, header SetHeader, b []byte) error {
+	set := OptionsTemplateSet{Header: header}
+	for len(b) >= 6 {
+		tpl := OptionTemplateRecord{
+			ID:              binary.BigEndian.Uint16(b[0:]),
+			FieldCount:      binary.BigEndian.Uint16(b[2:]),
+			ScopeFieldCount: binary.BigEndian.Uint16(b[4:]),
+		}
+		var err error
+		tpl.Fields, b, err = decodeFields(b[6:], int(tpl.FieldCount))
+		if err != nil {
+			return err
+		}
+		d.learn(msg.Header.DomainID, tpl.ID, tpl.Fields)
+		set.OptionTemplates = append(set.OptionTemplates, tpl)
+	}
+	msg.OptionsTemplateSet = append(msg.OptionsTemplateSet, set)
+	return nil
+}
+
+//learn a template, a template with no field withdraws it
+func (d *Decoder) learn(domainID uint32, templateID uint16, fields []FieldSpecifier) {
+	key := templateKey{domainID, templateID}
+	if len(fields) == 0 {
+		delete(d.templates, key)
+		return
+	}
+	d.templates[key] = fields
+}
+
+//decode count field specifiers, returning the bytes that follow them
+func decodeFields(b []byte, count int) ([]FieldSpecifier, []byte, error) {
+	fields := make([]FieldSpecifier, 0, count)
+	for i := 0; i < count; i++ {
+		if len(b) < 4 {
+			return nil, nil, ErrShortMessage
+		}
+		field := FieldSpecifier{
+			ID:     binary.BigEndian.Uint16(b[0:]),
+			Length: binary.BigEndian.Uint16(b[2:]),
+		}
+		b = b[4:]
+		if field.ID&ENTERPRISE_BIT != 0 {
+			if len(b) < 4 {
+				return nil, nil, ErrShortMessage
+			}
+			field.EnterpriseNo = binary.BigEndian.Uint32(b)
+			b = b[4:]
+		}
+		fields = append(fields, field)
+	}
+	return fields, b, nil
+}
+
+func (d *Decoder) decodeDataSet(msg *Message, header SetHeader, b []byte) error {
+	fields, found := d.templates[templateKey{msg.Header.DomainID, header.ID}]
+	if !found {
+		d.Missing++
+		return nil
+	}
+
+	//smallest record, variable length fields hold at least their length
+	minLength := 0
+	for _, field := range fields {
+		if field.Length == VARIABLE_LENGTH {
+			minLength++
+		} else {
+			minLength += int(field.Length)
+		}
+	}
+	if minLength == 0 {
+		return nil
+	}
+
+	set := DataSet{Header: header}
+	for len(b) >= minLength {
+		record := make([]DataField, 0, len(fields))
+		for _, field := range fields {
+			length := int(field.Length)
+			if field.Length == VARIABLE_LENGTH {
+				//RFC7011 section 7: 1 byte length, or 255 and 2 bytes length
+				if len(b) < 1 {
+					return ErrShortMessage
+				}
+				length, b = int(b[0]), b[1:]
+				if length == 255 {
+					if len(b) < 2 {
+						return ErrShortMessage
+					}
+					length, b = int(binary.BigEndian.Uint16(b)), b[2:]
+				}
+			}
+			if len(b) < length {
+				return ErrShortMessage
+			}
+			value := append([]byte{}, b[:length]...)
+			b = b[length:]
+			record = append(record, DataField{
+				FieldID:      field.ID,
+				EnterpriseNo: field.EnterpriseNo,
+				Value:        interpretField(field, value),
+			})
+		}
+		set.Records = append(set.Records, record)
+	}
+	set.padding = len(b)
+	msg.DataSet = append(msg.DataSet, set)
+	return nil
+}
+
+//interpret a field value according to the type of its information element,
+//values of unknown elements are kept as bytes
+func interpretField(field FieldSpecifier, b []byte) interface{} {
+	element, found := InfoModel[ElementKey{field.EnterpriseNo, field.ID &^ ENTERPRISE_BIT}]
+	if !found {
+		return b
+	}
+	if element.Type == Float64 && len(b) == 4 {
+		//float64 sent as float32 - RFC7011 section 6.2
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	}
+	return Interpret(expand(b, element.Type), element.Type)
+}
+
+//expand integers sent with a reduced size encoding - RFC7011 section 6.2
+func expand(b []byte, t FieldType) *[]byte {
+	size := t.minLen()
+	if len(b) == 0 || len(b) >= size {
+		return &b
+	}
+	switch t {
+	case Uint16, Uint32, Uint64, Int16, Int32, Int64:
+		e := make([]byte, size)
+		if (t == Int16 || t == Int32 || t == Int64) && b[0]&0x80 != 0 {
+			for i := range e {
+				e[i] = 0xff
+			}
+		}
+		copy(e[size-len(b):], b)
+		return &e
+	}
+	return &b
+}
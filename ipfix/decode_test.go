@@ -0,0 +1,153 @@
+package ipfix
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		ips  []string
+	}{
+		{"ipv4", []string{"10.0.0.1", "10.0.0.2"}},
+		{"ipv6", []string{"2001:db8::1", "2001:db8::2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewExporter(time.Hour, 0, DefaultMTU)
+			msg := e.GenerateNetflow(rand.New(rand.NewSource(1)), 7, tt.ips)
+			if len(msg.DataSet[0].Records) < 2 {
+				t.Fatalf("generated %d records, want a multi-record set", len(msg.DataSet[0].Records))
+			}
+			b := e.Encode(*msg)
+
+			decoded, err := NewDecoder().Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Header.DomainID != 7 || int(decoded.Header.Length) != len(b) {
+				t.Errorf("decoded header %+v, want domain 7 and length %d", decoded.Header, len(b))
+			}
+			if got, want := decoded.TemplateSet[0].Templates, msg.TemplateSet[0].Templates; !reflect.DeepEqual(got, want) {
+				t.Errorf("decoded templates %+v, want %+v", got, want)
+			}
+			if got, want := len(decoded.DataSet[0].Records), len(msg.DataSet[0].Records); got != want {
+				t.Fatalf("decoded %d records, want %d", got, want)
+			}
+			for i, record := range decoded.DataSet[0].Records {
+				for j, field := range record {
+					sent := msg.DataSet[0].Records[i][j]
+					if field.FieldID != sent.FieldID {
+						t.Fatalf("record %d field %d is element %d, want %d", i, j, field.FieldID, sent.FieldID)
+					}
+					// addresses are the only values generated with the decoded type
+					if ip, ok := field.Value.(net.IP); ok && !ip.Equal(sent.Value.(net.IP)) {
+						t.Errorf("record %d field %d is %s, want %s", i, j, ip, sent.Value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeReducedSizeIntegers(t *testing.T) {
+	tests := []struct {
+		name  string
+		field FieldSpecifier
+		value []byte
+		want  interface{}
+	}{
+		{"unsigned64 on 4 bytes", FieldSpecifier{ID: 1, Length: 4}, []byte{0, 1, 0, 0}, uint64(65536)},
+		{"unsigned64 on 1 byte", FieldSpecifier{ID: 2, Length: 1}, []byte{200}, uint64(200)},
+		{"unsigned16 on 1 byte", FieldSpecifier{ID: 7, Length: 1}, []byte{80}, uint16(80)},
+		{"unsigned32 full size", FieldSpecifier{ID: 21, Length: 4}, []byte{0, 0, 1, 0}, uint32(256)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := []FieldSpecifier{tt.field}
+			msg := Message{
+				Header:      MessageHeader{Version: VERSION},
+				TemplateSet: []TemplateSet{CreateFieldsTemplateSet(300, fields)},
+				DataSet:     []DataSet{CreateDataSet(300, CreateFieldsDataRecord(fields, []interface{}{tt.value}))},
+			}
+			decoded, err := NewDecoder().Decode(Encode(msg, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decoded.DataSet[0].Records[0][0].Value; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decoded %v (%T), want %v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMissingTemplate(t *testing.T) {
+	e := NewExporter(time.Hour, 0, DefaultMTU)
+	r := rand.New(rand.NewSource(1))
+	first := e.Encode(*e.GenerateNetflow(r, 0, nil))
+	second := e.Encode(*e.GenerateNetflow(r, 0, nil))
+
+	d := NewDecoder()
+	msg, err := d.Decode(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.DataSet) != 0 || d.Missing != 1 {
+		t.Errorf("decoded %d data sets and %d missing templates, want 0 and 1", len(msg.DataSet), d.Missing)
+	}
+
+	// once announced the template decodes the following messages
+	if _, err := d.Decode(first); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = d.Decode(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.DataSet) != 1 || d.Missing != 1 {
+		t.Errorf("decoded %d data sets and %d missing templates, want 1 and 1", len(msg.DataSet), d.Missing)
+	}
+
+	// templates are scoped by observation domain
+	other := append([]byte{}, second...)
+	other[15] = 1
+	if _, err := d.Decode(other); err != nil {
+		t.Fatal(err)
+	}
+	if d.Missing != 2 {
+		t.Errorf("counted %d missing templates, want 2 for another domain", d.Missing)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	e := NewExporter(time.Hour, 0, DefaultMTU)
+	valid := e.Encode(*e.GenerateNetflow(rand.New(rand.NewSource(1)), 0, nil))
+	longSet := append([]byte{}, valid...)
+	longSet[18], longSet[19] = 0xff, 0xff
+	tests := []struct {
+		name    string
+		payload []byte
+		short   bool
+	}{
+		{"shorter than a header", valid[:10], true},
+		{"truncated", valid[:len(valid)-4], true},
+		{"other version", append([]byte{0, 9}, valid[2:]...), false},
+		{"set longer than the message", longSet, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDecoder().Decode(tt.payload)
+			if err == nil {
+				t.Fatal("decoded an invalid message")
+			}
+			if short := errors.Is(err, ErrShortMessage); short != tt.short {
+				t.Errorf("got error %v, want a short message error %v", err, tt.short)
+			}
+		})
+	}
+}
@@ -52,7 +52,7 @@ func writeTemplate(buf *bytes.Buffer, tplRecord TemplateRecord) {
 		for _, field := range tplRecord.Fields {
 			binary.Write(buf, binary.BigEndian, field.ID)
 			binary.Write(buf, binary.BigEndian, field.Length)
-			if field.ID >= 0x80 { // E == 1
+			if field.ID&ENTERPRISE_BIT != 0 { // E == 1
 				binary.Write(buf, binary.BigEndian, field.EnterpriseNo)
 			}
 		}
@@ -82,13 +82,16 @@ func writeOptionsTemplate(buf *bytes.Buffer, tplRecord OptionTemplateRecord) {
 		for i := 0; i < int(tplRecord.ScopeFieldCount); i++ {
 			binary.Write(buf, binary.BigEndian, (tplRecord.Fields[i]).ID)
 			binary.Write(buf, binary.BigEndian, (tplRecord.Fields[i]).Length)
-			if (tplRecord.Fields[i]).ID >= 0x80 { // E == 1
+			if (tplRecord.Fields[i]).ID&ENTERPRISE_BIT != 0 { // E == 1
 				binary.Write(buf, binary.BigEndian, (tplRecord.Fields[i]).EnterpriseNo)
 			}
 		}
 		for i := int(tplRecord.ScopeFieldCount); i < int(tplRecord.FieldCount); i++ {
 			binary.Write(buf, binary.BigEndian, (tplRecord.Fields[i]).ID)
 			binary.Write(buf, binary.BigEndian, (tplRecord.Fields[i]).Length)
+			if (tplRecord.Fields[i]).ID&ENTERPRISE_BIT != 0 { // E == 1
+				binary.Write(buf, binary.BigEndian, (tplRecord.Fields[i]).EnterpriseNo)
+			}
 		}
 	}
 }
@@ -97,8 +100,15 @@ func writeDataSet(buf *bytes.Buffer, dataSet []DataSet) {
 	for _, flowSet := range dataSet {
 		binary.Write(buf, binary.BigEndian, flowSet.Header.ID)
 		binary.Write(buf, binary.BigEndian, flowSet.Header.Length)
-		for _, field := range flowSet.DataFields {
-			binary.Write(buf, binary.BigEndian, field.Value)
+		for _, record := range flowSet.Records {
+			for _, field := range record {
+				if v, ok := field.Value.(VariableLength); ok {
+					buf.Write(variableLengthPrefix(len(v)))
+					buf.Write(v)
+					continue
+				}
+				binary.Write(buf, binary.BigEndian, field.Value)
+			}
 		}
 		for i := 0; i < flowSet.padding; i++ {
 			binary.Write(buf, binary.BigEndian, PADDING)
@@ -134,7 +144,7 @@ func fillTemplate(tplSet *TemplateSet) {
 		length += 4 //t head
 		for _, field := range tpl.Fields {
 			length += 4 //field
-			if field.ID > 0x80 {
+			if field.ID&ENTERPRISE_BIT != 0 {
 				length += 4 //enterpriseNo
 			}
 		}
@@ -146,13 +156,12 @@ func fillOptionTemplate(tplSet *OptionsTemplateSet) {
 	for _, tpl := range tplSet.OptionTemplates {
 		if tpl.FieldCount > 0 {
 			length += 6 //options template head
-			for i := 0; i < int(tpl.ScopeFieldCount); i++ {
+			for i := 0; i < int(tpl.FieldCount); i++ {
 				length += 4 // id + length
-				if tpl.Fields[i].ID > 0x80 {
-					length += 4
+				if tpl.Fields[i].ID&ENTERPRISE_BIT != 0 {
+					length += 4 //enterpriseNo
 				}
 			}
-			length += (tpl.FieldCount - tpl.ScopeFieldCount) * 4
 		}
 	}
 	//padding
@@ -167,13 +176,48 @@ func fillOptionTemplate(tplSet *OptionsTemplateSet) {
 //or cal by user
 func fillDataSet(dataset *DataSet) {
 	length := uint16(4) // set len
-	for _, d := range dataset.DataFields {
-		length += uint16(InfoModel[ElementKey{0, d.FieldID}].Type.minLen())
-		//fmt.Printf("FieldID:%d,reflectLen:%d,InfoModelLen:%d\n", d.FieldID, reflect.TypeOf(d.Value).Size(), InfoModel[ElementKey{0, d.FieldID}].Type.minLen())
+	variable := false
+	minRecord := 0
+	for i, record := range dataset.Records {
+		size := RecordSize(record)
+		length += uint16(size)
+		if i == 0 || size < minRecord {
+			minRecord = size
+		}
+		for _, d := range record {
+			_, ok := d.Value.(VariableLength)
+			variable = variable || ok
+		}
 	}
-	if length%4 != 0 {
-		dataset.padding = int(4 - length%4)
-		length += 4 - length%4
+	//padding is optional and must be shorter than the records, it could
+	//otherwise be read as records, of empty variable length values or of
+	//fields shorter than it - RFC7011 section 3.3.1
+	if padding := int(4-length%4) % 4; padding > 0 && !variable && padding < minRecord {
+		dataset.padding = padding
+		length += uint16(padding)
 	}
 	dataset.Header.Length = length
 }
+
+//RecordSize returns the length in bytes of an encoded data record, values
+//are encoded with the length of their field
+func RecordSize(record []DataField) int {
+	size := 0
+	for _, d := range record {
+		if v, ok := d.Value.(VariableLength); ok {
+			size += len(variableLengthPrefix(len(v))) + len(v)
+		} else if n := binary.Size(d.Value); n > 0 {
+			size += n
+		}
+	}
+	return size
+}
+
+//length of a variable length value: 1 byte, or 255 and 2 bytes from 255
+//bytes on - RFC7011 section 7
+func variableLengthPrefix(n int) []byte {
+	if n < 255 {
+		return []byte{uint8(n)}
+	}
+	return []byte{255, uint8(n >> 8), uint8(n)}
+}
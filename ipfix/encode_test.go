@@ -0,0 +1,43 @@
+package ipfix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeEnterpriseOptionsTemplate(t *testing.T) {
+	fields := []FieldSpecifier{
+		{ID: 144, Length: 4}, //exportingProcessId, scope
+		{ID: 1 | ENTERPRISE_BIT, Length: 4, EnterpriseNo: 32473}, //enterprise scope
+		{ID: 34, Length: 4}, //samplingInterval
+		{ID: 2 | ENTERPRISE_BIT, Length: 8, EnterpriseNo: 32473}, //enterprise option
+	}
+	msg := Message{
+		Header: MessageHeader{Version: VERSION, DomainID: 1},
+		OptionsTemplateSet: []OptionsTemplateSet{{
+			Header: SetHeader{ID: 3},
+			OptionTemplates: []OptionTemplateRecord{{
+				ID:              300,
+				FieldCount:      uint16(len(fields)),
+				ScopeFieldCount: 2,
+				Fields:          fields,
+			}},
+		}},
+	}
+
+	b := Encode(msg, 0)
+	//header, set header, options template header, 4 fields and 2 enterprise numbers, padding
+	if want := 16 + 4 + 6 + 4*4 + 2*4 + 2; len(b) != want {
+		t.Fatalf("encoded %d bytes, want %d", len(b), want)
+	}
+	decoded, err := NewDecoder().Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.OptionsTemplateSet) != 1 || len(decoded.OptionsTemplateSet[0].OptionTemplates) != 1 {
+		t.Fatalf("decoded %+v, want one options template", decoded.OptionsTemplateSet)
+	}
+	if got := decoded.OptionsTemplateSet[0].OptionTemplates[0].Fields; !reflect.DeepEqual(got, fields) {
+		t.Errorf("decoded fields %+v, want %+v", got, fields)
+	}
+}
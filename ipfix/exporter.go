@@ -0,0 +1,275 @@
+package ipfix
+
+import (
+	"math"
+	"math/rand"
+	"nflow-generator/clock"
+	"nflow-generator/scenario"
+	"sort"
+	"time"
+)
+
+//Exporter keeps the exporting process state needed to announce templates
+//once and re-send them periodically - RFC7011#section-8.4
+type Exporter struct {
+	TemplateRefreshInterval time.Duration      // re-send templates after this delay, 0 to disable
+	TemplateRefreshPackets  int                // re-send templates every N messages, 0 to disable
+	MTU                     int                // maximum message size used to batch data records
+	Scenario                *scenario.Scenario // draw records from this scenario when set
+	Fields                  []FieldSpecifier   // template of the records when set, with generated values
+	domains                 map[uint32]*domainState
+	templateID              uint16 // last template id given by the exporter
+}
+
+//state kept per observation domain
+type domainState struct {
+	templates map[int]*templateState // keyed by ip version
+	seqNum    uint32
+}
+
+//state kept per template of an observation domain
+type templateState struct {
+	id            uint16
+	fields        []FieldSpecifier // fields of the announced template
+	lastTemplate  time.Time
+	sinceTemplate int
+}
+
+const (
+	DefaultMTU = 1400
+	MaxMTU     = math.MaxUint16 //message lengths are held in 16 bits
+)
+
+func NewExporter(refreshInterval time.Duration, refreshPackets int, mtu int) *Exporter {
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	return &Exporter{
+		TemplateRefreshInterval: refreshInterval,
+		TemplateRefreshPackets:  refreshPackets,
+		MTU:                     mtu,
+		domains:                 map[uint32]*domainState{},
+	}
+}
+
+func (e *Exporter) domain(domainID uint32) *domainState {
+	d, found := e.domains[domainID]
+	if !found {
+		d = &domainState{templates: map[int]*templateState{}}
+		e.domains[domainID] = d
+	}
+	return d
+}
+
+func (e *Exporter) template(d *domainState, ipVersion int) *templateState {
+	t, found := d.templates[ipVersion]
+	if !found {
+		//template ids are scoped by observation domain, each exporter
+		//numbers them on its own so they don't depend on the other ones
+		if e.templateID < initialTemplateId {
+			e.templateID = initialTemplateId
+		}
+		e.templateID++
+		t = &templateState{id: e.templateID}
+		d.templates[ipVersion] = t
+	}
+	return t
+}
+
+//ip version of the records of a template, 0 when it is unknown
+func (e *Exporter) ipVersion(domainID uint32, templateID uint16) int {
+	for ipVersion, t := range e.domain(domainID).templates {
+		if t.id == templateID {
+			return ipVersion
+		}
+	}
+	return 0
+}
+
+//elements of the template of the records of an ip version
+func (e *Exporter) templateFields(ipVersion int) []FieldSpecifier {
+	if e.Fields != nil {
+		return e.Fields
+	}
+	ids := GetIDs()
+	if ipVersion == 6 {
+		ids = GetIPv6IDs()
+	}
+	if e.Scenario != nil {
+		ids = append(ids, scenarioIDs()...)
+	}
+	return Fields(ids)
+}
+
+//embed the template set in msg when announcing or refreshing it
+func (e *Exporter) announce(msg *Message, t *templateState, fields []FieldSpecifier) {
+	now := clock.Now()
+	if e.templateDue(t, now) {
+		msg.TemplateSet = append(msg.TemplateSet, CreateFieldsTemplateSet(t.id, fields))
+		t.fields = fields
+		t.lastTemplate = now
+		t.sinceTemplate = 0
+	}
+	t.sinceTemplate++
+}
+
+//check if a template has to be sent in the next message
+func (e *Exporter) templateDue(t *templateState, now time.Time) bool {
+	if t.lastTemplate.IsZero() {
+		return true
+	}
+	if e.TemplateRefreshInterval > 0 && now.Sub(t.lastTemplate) >= e.TemplateRefreshInterval {
+		return true
+	}
+	if e.TemplateRefreshPackets > 0 && t.sinceTemplate >= e.TemplateRefreshPackets {
+		return true
+	}
+	return false
+}
+
+//GenerateNetflow builds the next message of an observation domain,
+//packing as many data records as fit in the exporter MTU.
+//All the records share the ip version of ips.
+//The template set is only embedded when announcing or refreshing it.
+func (e *Exporter) GenerateNetflow(r *rand.Rand, domainID uint32, ips []string) *Message {
+	ipVersion := 4
+	if IsIPv6(ips) {
+		ipVersion = 6
+	}
+	t := e.template(e.domain(domainID), ipVersion)
+	fields := e.templateFields(ipVersion)
+	ids := FieldIDs(fields)
+
+	msg := &Message{
+		Header: MessageHeader{
+			Version:    VERSION,
+			Length:     0,
+			ExportTime: 0,
+			SequenceNo: 0,
+			DomainID:   domainID,
+		},
+	}
+
+	e.announce(msg, t, fields)
+
+	//records are added while they fit in the MTU, at least one is always sent
+	dataSet := CreateDataSet(t.id)
+	space := e.recordSpace(msg)
+	for len(dataSet.Records) == 0 || space >= FieldsRecordLength(fields) {
+		var vals []interface{}
+		switch {
+		case e.Fields != nil:
+			vals = GetFieldVals(r, fields, ips)
+		case e.Scenario != nil:
+			vals = GetFlowVals(ids, e.Scenario.Next(r, ips))
+		default:
+			vals = GetVals(r, ips)
+		}
+		record := CreateFieldsDataRecord(fields, vals)
+		size := RecordSize(record)
+		if len(dataSet.Records) > 0 && size > space {
+			//a longer record of variable length fields is dropped
+			break
+		}
+		dataSet.Records = append(dataSet.Records, record)
+		space -= size
+	}
+	msg.DataSet = []DataSet{dataSet}
+
+	return msg
+}
+
+//space left for data records in the MTU after the message header and the
+//already filled sets
+func (e *Exporter) recordSpace(msg *Message) int {
+	return e.MTU - setsSize(msg)
+}
+
+//size of the message header, the template sets and the header of a data set
+//with its worst case padding
+func setsSize(msg *Message) int {
+	size := 16 //message header
+	for i := range msg.TemplateSet {
+		fillTemplate(&(msg.TemplateSet[i]))
+		size += int(msg.TemplateSet[i].Header.Length)
+	}
+	return size + 4 + 3 //data set header and worst case padding
+}
+
+//MinMTU returns the size of the smallest message that announces a template
+//of the exporter with one record of it
+func (e *Exporter) MinMTU() int {
+	min := 0
+	for _, ipVersion := range []int{4, 6} {
+		fields := e.templateFields(ipVersion)
+		msg := Message{TemplateSet: []TemplateSet{CreateFieldsTemplateSet(0, fields)}}
+		if size := setsSize(&msg) + FieldsRecordLength(fields); size > min {
+			min = size
+		}
+	}
+	return min
+}
+
+//Adopt prepares a part of a message generated by another exporter of the
+//same configuration to be sent by this one: the templates follow the state
+//of this exporter, so that each collector of a hash distribution gets its
+//own template ids and refreshes
+func (e *Exporter) Adopt(from *Exporter, msg Message) Message {
+	if from == e {
+		return msg
+	}
+	msg.TemplateSet = nil
+	var sets []DataSet
+	for _, dataSet := range msg.DataSet {
+		ipVersion := from.ipVersion(msg.Header.DomainID, dataSet.Header.ID)
+		t := e.template(e.domain(msg.Header.DomainID), ipVersion)
+		e.announce(&msg, t, e.templateFields(ipVersion))
+		dataSet.Header.ID = t.id
+		sets = append(sets, dataSet)
+	}
+	msg.DataSet = sets
+	return msg
+}
+
+//Encode a message of the exporter with the current export time and update
+//the domain sequence number, counting the data records sent modulo 2^32 -
+//RFC7011#section-3.1
+func (e *Exporter) Encode(msg Message) []byte {
+	msg.Header.ExportTime = uint32(clock.Now().Unix())
+	d := e.domain(msg.Header.DomainID)
+	seqNo := d.seqNum
+	for _, dataSet := range msg.DataSet {
+		d.seqNum += uint32(len(dataSet.Records))
+	}
+	return Encode(msg, seqNo)
+}
+
+//TemplateMessage encodes a message holding the templates already announced
+//in an observation domain, so that they are known to a new transport session
+//before its first data set. It returns nil if no template was announced.
+func (e *Exporter) TemplateMessage(domainID uint32) []byte {
+	d := e.domain(domainID)
+	var versions []int
+	for ipVersion, t := range d.templates {
+		if t.fields != nil {
+			versions = append(versions, ipVersion)
+		}
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	sort.Ints(versions)
+
+	msg := Message{
+		Header: MessageHeader{
+			Version:    VERSION,
+			ExportTime: uint32(clock.Now().Unix()),
+			DomainID:   domainID,
+		},
+	}
+	for _, ipVersion := range versions {
+		t := d.templates[ipVersion]
+		msg.TemplateSet = append(msg.TemplateSet, CreateFieldsTemplateSet(t.id, t.fields))
+	}
+	return Encode(msg, d.seqNum)
+}
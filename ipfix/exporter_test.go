@@ -0,0 +1,47 @@
+package ipfix
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestMinMTU(t *testing.T) {
+	tests := []struct {
+		name string
+		ips  []string
+	}{
+		{"ipv4", nil},
+		{"ipv6", []string{"2001:db8::1", "2001:db8::2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewExporter(time.Hour, 0, 0)
+			e.MTU = e.MinMTU()
+			msg := e.GenerateNetflow(rand.New(rand.NewSource(1)), 0, tt.ips)
+			if len(msg.TemplateSet) != 1 || len(msg.DataSet[0].Records) != 1 {
+				t.Fatalf("got %d template sets and %d records, want the template and one record",
+					len(msg.TemplateSet), len(msg.DataSet[0].Records))
+			}
+			if b := e.Encode(*msg); len(b) > e.MTU {
+				t.Errorf("encoded %d bytes, more than the minimum mtu %d", len(b), e.MTU)
+			}
+		})
+	}
+}
+
+func TestEncodeExportTime(t *testing.T) {
+	e := NewExporter(time.Hour, 0, 0)
+	msg := e.GenerateNetflow(rand.New(rand.NewSource(1)), 0, nil)
+	before := uint32(time.Now().Unix())
+	b := e.Encode(*msg)
+	after := uint32(time.Now().Unix())
+
+	decoded, err := NewDecoder().Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Header.ExportTime; got < before || got > after {
+		t.Errorf("export time %d, want between %d and %d", got, before, after)
+	}
+}
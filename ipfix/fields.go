@@ -0,0 +1,365 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"nflow-generator/clock"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DefaultStringLength is the field length of string and octetArray elements
+//declared without length
+const DefaultStringLength = 16
+
+//seconds between the NTP era (1900) and the unix epoch
+const ntpEpochOffset = 2208988800
+
+var (
+	defaultIPv4s    = []net.IP{net.ParseIP("10.10.29.7").To4(), net.ParseIP("10.10.29.8").To4()}
+	defaultIPv6s    = []net.IP{net.ParseIP("2001:db8:10:29::7"), net.ParseIP("2001:db8:10:29::8")}
+	protocols       = []uint8{6, 6, 6, 17, 17, 1} // mostly tcp, some udp and icmp
+	serverPorts     = []uint16{80, 443, 53, 22, 123, 993, 3306, 8080}
+	applicationName = []string{"http", "https", "dns", "ssh", "ntp", "imaps", "mysql", "http-alt"}
+	urlChars        = "abcdefghijklmnopqrstuvwxyz0123456789/-_"
+)
+
+//ParseFields reads a template declared as comma separated element names of
+//the information model, including the elements of a loaded registry, each
+//optionally followed by ':' and a field length,
+//e.g. 'octetDeltaCount:4,packetDeltaCount,sourceIPv4Address,applicationName:32'.
+//Integers can be sent with a reduced size, strings and octet arrays default
+//to DefaultStringLength or are sent with a variable length when followed by
+//':variable', and other types have a fixed length.
+func ParseFields(s string) ([]FieldSpecifier, error) {
+	var fields []FieldSpecifier
+	names := nameIndex()
+	for _, value := range strings.Split(s, ",") {
+		name, length := strings.TrimSpace(value), ""
+		if i := strings.Index(name, ":"); i >= 0 {
+			name, length = name[:i], name[i+1:]
+		}
+		key, found := names[name]
+		if !found {
+			return nil, fmt.Errorf("information element %s is unknown", name)
+		}
+		element := InfoModel[key]
+		field := FieldSpecifier{
+			ID:           key.ElementID,
+			Length:       uint16(element.Type.minLen()),
+			EnterpriseNo: key.EnterpriseNo,
+		}
+		if key.EnterpriseNo != 0 {
+			field.ID |= ENTERPRISE_BIT
+		}
+		switch element.Type {
+		case Unknown:
+			return nil, fmt.Errorf("information element %s has an unsupported type", name)
+		case String, OctetArray:
+			field.Length = DefaultStringLength
+		}
+		if length == "variable" {
+			if element.Type != String && element.Type != OctetArray {
+				return nil, fmt.Errorf("information element %s has a fixed length type", name)
+			}
+			field.Length = VARIABLE_LENGTH
+		} else if length != "" {
+			n, err := strconv.Atoi(length)
+			if err != nil || !validLength(element.Type, n) {
+				return nil, fmt.Errorf("length %s of information element %s is not valid", length, name)
+			}
+			field.Length = uint16(n)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+//nameIndex maps the names of the information model to their elements.
+//Keys are visited in order so that a name used twice always resolves to the
+//element of the lowest enterprise number and id.
+func nameIndex() map[string]ElementKey {
+	keys := make([]ElementKey, 0, len(InfoModel))
+	for key := range InfoModel {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].EnterpriseNo != keys[j].EnterpriseNo {
+			return keys[i].EnterpriseNo < keys[j].EnterpriseNo
+		}
+		return keys[i].ElementID < keys[j].ElementID
+	})
+	names := make(map[string]ElementKey, len(keys))
+	for _, key := range keys {
+		if _, found := names[InfoModel[key].Name]; !found {
+			names[InfoModel[key].Name] = key
+		}
+	}
+	return names
+}
+
+//check a field length against the type of its element - RFC7011 section 6.2
+func validLength(t FieldType, n int) bool {
+	switch t {
+	case Uint16, Uint32, Uint64, Int16, Int32, Int64:
+		return n >= 1 && n <= t.minLen()
+	case Float64:
+		return n == 4 || n == 8
+	case String, OctetArray:
+		return n >= 1 && n < int(VARIABLE_LENGTH)
+	}
+	return n == t.minLen()
+}
+
+//FieldIDs returns the element ids of fields
+func FieldIDs(fields []FieldSpecifier) []uint16 {
+	var ids []uint16
+	for _, field := range fields {
+		ids = append(ids, field.ID)
+	}
+	return ids
+}
+
+//HasOctetCount tells if fields carry the octets of the flows
+func HasOctetCount(fields []FieldSpecifier) bool {
+	for _, field := range fields {
+		if field.EnterpriseNo == 0 && (field.ID == 1 || field.ID == 23 || field.ID == 85) {
+			return true
+		}
+	}
+	return false
+}
+
+//FieldsRecordLength returns the length in bytes of a data record of fields,
+//the smallest one when fields have a variable length
+func FieldsRecordLength(fields []FieldSpecifier) int {
+	length := 0
+	for _, field := range fields {
+		if field.Length == VARIABLE_LENGTH {
+			length++ //length of an empty value
+		} else {
+			length += int(field.Length)
+		}
+	}
+	return length
+}
+
+//flow described by the values of a generated record, so that its addresses,
+//ports, counters and timestamps are consistent across elements
+type flowValues struct {
+	protocol         uint8
+	srcPort, dstPort uint16
+	src4, dst4       net.IP
+	src6, dst6       net.IP
+	ipv6             bool // the flow addresses are the ipv6 ones
+	packets, octets  uint64
+	start, end       time.Time
+}
+
+func newFlowValues(r *rand.Rand, ips []string) flowValues {
+	f := flowValues{
+		protocol: protocols[r.Intn(len(protocols))],
+		src4:     defaultIPv4s[0],
+		dst4:     defaultIPv4s[1],
+		src6:     defaultIPv6s[0],
+		dst6:     defaultIPv6s[1],
+		packets:  uint64(1 + r.Intn(100)),
+	}
+	if f.protocol != 1 {
+		f.srcPort = uint16(1024 + r.Intn(64511))
+		f.dstPort = serverPorts[r.Intn(len(serverPorts))]
+	}
+	f.octets = f.packets * uint64(64+r.Intn(1437))
+	if len(ips) > 0 {
+		src := net.ParseIP(ips[r.Int()%len(ips)])
+		dst := net.ParseIP(ips[r.Int()%len(ips)])
+		f.ipv6 = IsIPv6(ips)
+		if f.ipv6 {
+			f.src6, f.dst6 = src.To16(), dst.To16()
+		} else {
+			f.src4, f.dst4 = src.To4(), dst.To4()
+		}
+	}
+	f.end = clock.Now()
+	f.start = f.end.Add(-time.Duration(10+r.Intn(990)) * time.Millisecond)
+	return f
+}
+
+//GetFieldVals returns the values of a generated record of fields, encoded
+//with the length of each field, or as VariableLength values
+func GetFieldVals(r *rand.Rand, fields []FieldSpecifier, ips []string) []interface{} {
+	f := newFlowValues(r, ips)
+	var vals []interface{}
+	for _, field := range fields {
+		vals = append(vals, f.value(r, field))
+	}
+	return vals
+}
+
+//value of a field, from the flow for the elements describing it and random
+//values of the element type otherwise
+func (f flowValues) value(r *rand.Rand, field FieldSpecifier) interface{} {
+	element := InfoModel[ElementKey{field.EnterpriseNo, field.ID &^ ENTERPRISE_BIT}]
+	if field.EnterpriseNo == 0 {
+		switch field.ID {
+		case 1, 23, 85: //octetDeltaCount, postOctetDeltaCount, octetTotalCount
+			return encodeUint(f.octets, field.Length)
+		case 2, 24, 86: //packetDeltaCount, postPacketDeltaCount, packetTotalCount
+			return encodeUint(f.packets, field.Length)
+		case 4: //protocolIdentifier
+			return encodeUint(uint64(f.protocol), field.Length)
+		case 6: //tcpControlBits
+			if f.protocol == 6 {
+				return encodeUint(0x1b, field.Length) //FIN, SYN, PSH, ACK
+			}
+			return encodeUint(0, field.Length)
+		case 7: //sourceTransportPort
+			return encodeUint(uint64(f.srcPort), field.Length)
+		case 11: //destinationTransportPort
+			return encodeUint(uint64(f.dstPort), field.Length)
+		case 8: //sourceIPv4Address
+			return f.src4
+		case 12: //destinationIPv4Address
+			return f.dst4
+		case 27: //sourceIPv6Address
+			return f.src6
+		case 28: //destinationIPv6Address
+			return f.dst6
+		case 60: //ipVersion
+			if f.ipv6 {
+				return encodeUint(6, field.Length)
+			}
+			return encodeUint(4, field.Length)
+		case 22: //flowStartSysUpTime
+			return encodeUint(sysUpTime(f.start), field.Length)
+		case 21: //flowEndSysUpTime
+			return encodeUint(sysUpTime(f.end), field.Length)
+		}
+	}
+
+	switch element.Type {
+	case Uint8, Uint16, Uint32, Uint64:
+		if strings.HasSuffix(element.Name, "Count") {
+			return encodeUint(f.packets, field.Length)
+		}
+		return randomBytes(r, int(field.Length))
+	case Int8, Int16, Int32, Int64:
+		return randomBytes(r, int(field.Length))
+	case Float32:
+		return encodeUint(uint64(math.Float32bits(r.Float32())), field.Length)
+	case Float64:
+		if field.Length == 4 {
+			return encodeUint(uint64(math.Float32bits(r.Float32())), field.Length)
+		}
+		return encodeUint(math.Float64bits(r.Float64()), field.Length)
+	case Boolean:
+		//true is 1 and false is 2 - RFC7011 section 6.1.5
+		return []byte{uint8(1 + r.Intn(2))}
+	case MacAddress:
+		mac := randomBytes(r, 6)
+		mac[0] = mac[0]&0xfe | 0x02 //locally administered unicast
+		return mac
+	case String:
+		if field.Length == VARIABLE_LENGTH {
+			return VariableLength(f.stringValue(r, element.Name))
+		}
+		return encodeString(f.stringValue(r, element.Name), field.Length)
+	case OctetArray:
+		if field.Length == VARIABLE_LENGTH {
+			return VariableLength(randomBytes(r, 1+r.Intn(2*DefaultStringLength)))
+		}
+		return randomBytes(r, int(field.Length))
+	case DateTimeSeconds:
+		return encodeUint(uint64(f.time(element.Name).Unix()), field.Length)
+	case DateTimeMilliseconds:
+		return encodeUint(uint64(f.time(element.Name).UnixNano()/int64(time.Millisecond)), field.Length)
+	case DateTimeMicroseconds:
+		//NTP timestamp with the 11 lower bits of the fraction ignored - RFC7011 section 6.1.9
+		return encodeUint(ntpTime(f.time(element.Name))&^0x7ff, field.Length)
+	case DateTimeNanoseconds:
+		return encodeUint(ntpTime(f.time(element.Name)), field.Length)
+	case Ipv4Address:
+		return net.IPv4(10, byte(r.Intn(256)), byte(r.Intn(256)), byte(1+r.Intn(254))).To4()
+	case Ipv6Address:
+		ip := make(net.IP, 16)
+		copy(ip, defaultIPv6s[0][:4]) //2001:db8::/32
+		copy(ip[8:], randomBytes(r, 8))
+		return ip
+	}
+	return make([]byte, field.Length)
+}
+
+//time of a timestamp element, the start or end of the flow or now
+func (f flowValues) time(name string) time.Time {
+	switch {
+	case strings.Contains(name, "Start"):
+		return f.start
+	case strings.Contains(name, "End"):
+		return f.end
+	}
+	return clock.Now()
+}
+
+//text of a string element
+func (f flowValues) stringValue(r *rand.Rand, name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.Contains(lower, "interface"):
+		return fmt.Sprintf("eth%d", r.Intn(4))
+	case strings.Contains(lower, "application"):
+		return applicationName[r.Intn(len(applicationName))]
+	case strings.Contains(lower, "user"):
+		return fmt.Sprintf("user%d", r.Intn(100))
+	case strings.Contains(lower, "url") || strings.Contains(lower, "uri"):
+		//long enough to need the 3 bytes length of variable length fields
+		path := make([]byte, r.Intn(400))
+		for i := range path {
+			path[i] = urlChars[r.Intn(len(urlChars))]
+		}
+		return fmt.Sprintf("https://www.example.com/%s", path)
+	case strings.Contains(lower, "host"):
+		return fmt.Sprintf("host%d.example.com", r.Intn(100))
+	}
+	return fmt.Sprintf("%s-%d", name, r.Intn(10))
+}
+
+//milliseconds since the start of the exporter, like netflow v5 sysUptime
+func sysUpTime(t time.Time) uint64 {
+	return uint64(t.Sub(clock.Start())/time.Millisecond) + 1000
+}
+
+//encode an unsigned integer on length bytes, clamped to the largest value
+//of a reduced size encoding
+func encodeUint(n uint64, length uint16) []byte {
+	if length < 8 && n >= 1<<(8*length) {
+		n = 1<<(8*length) - 1
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b[8-length:]
+}
+
+//encode a string on length bytes, truncated or padded with zeros
+func encodeString(s string, length uint16) []byte {
+	b := make([]byte, length)
+	copy(b, s)
+	return b
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+//64 bits NTP timestamp: seconds since 1900 and fraction of second
+func ntpTime(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
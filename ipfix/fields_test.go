@@ -0,0 +1,132 @@
+package ipfix
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		fields string
+		want   []FieldSpecifier
+		err    bool
+	}{
+		{"octetDeltaCount:4,sourceIPv4Address", []FieldSpecifier{{ID: 1, Length: 4}, {ID: 8, Length: 4}}, false},
+		{"applicationName", []FieldSpecifier{{ID: 96, Length: DefaultStringLength}}, false},
+		{"applicationName:32", []FieldSpecifier{{ID: 96, Length: 32}}, false},
+		{"interfaceName:variable", []FieldSpecifier{{ID: 82, Length: VARIABLE_LENGTH}}, false},
+		{"mplsTopLabelStackSection:variable", []FieldSpecifier{{ID: 70, Length: VARIABLE_LENGTH}}, false},
+		{"octetDeltaCount:variable", nil, true}, // fixed length type
+		{"octetDeltaCount:9", nil, true},
+		{"sourceIPv4Address:2", nil, true},
+		{"applicationName:65535", nil, true},
+		{"notAnElement", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			got, err := ParseFields(tt.fields)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameIndexFirstWins(t *testing.T) {
+	key := ElementKey{EnterpriseNo: 32473, ElementID: 1}
+	InfoModel[key] = InfoElementEntry{FieldID: 1, Name: "octetDeltaCount", Type: FieldTypes["unsigned64"]}
+	defer delete(InfoModel, key)
+	for i := 0; i < 10; i++ {
+		if got := nameIndex()["octetDeltaCount"]; got != (ElementKey{0, 1}) {
+			t.Fatalf("octetDeltaCount resolves to %+v, want the IANA element", got)
+		}
+	}
+}
+
+func TestVariableLengthRoundTrip(t *testing.T) {
+	fields := []FieldSpecifier{{ID: 82, Length: VARIABLE_LENGTH}, {ID: 7, Length: 2}} //interfaceName, sourceTransportPort
+	tests := []struct {
+		length int
+		prefix []byte
+	}{
+		{0, []byte{0}},
+		{254, []byte{254}},
+		{255, []byte{255, 0, 255}},
+		{256, []byte{255, 1, 0}},
+	}
+	for _, tt := range tests {
+		value := strings.Repeat("x", tt.length)
+		record := CreateFieldsDataRecord(fields, []interface{}{VariableLength(value), HostTo2Net(1234)})
+		msg := Message{
+			Header:      MessageHeader{Version: VERSION},
+			TemplateSet: []TemplateSet{CreateFieldsTemplateSet(300, fields)},
+			DataSet:     []DataSet{CreateDataSet(300, record, record)},
+		}
+		b := Encode(msg, 0)
+
+		//header, template set of 2 fields, data set header then the first value
+		offset := 16 + 4 + 4 + 2*4 + 4
+		if got := b[offset : offset+len(tt.prefix)]; !bytes.Equal(got, tt.prefix) {
+			t.Errorf("%d bytes value encoded with length %v, want %v", tt.length, got, tt.prefix)
+		}
+		//no padding is added to sets of variable length records
+		if want := offset + 2*(len(tt.prefix)+tt.length+2); len(b) != want {
+			t.Errorf("%d bytes value encoded in %d bytes, want %d", tt.length, len(b), want)
+		}
+
+		decoded, err := NewDecoder().Decode(b)
+		if err != nil {
+			t.Fatalf("%d bytes value: %v", tt.length, err)
+		}
+		records := decoded.DataSet[0].Records
+		if len(records) != 2 {
+			t.Fatalf("%d bytes value decoded in %d records, want 2", tt.length, len(records))
+		}
+		for _, got := range records {
+			if got[0].Value != value || got[1].Value != uint16(1234) {
+				t.Errorf("%d bytes value decoded as %d bytes and port %v", tt.length, len(got[0].Value.(string)), got[1].Value)
+			}
+		}
+	}
+}
+
+func TestDecodeSkipsPadding(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []FieldSpecifier
+		value   []byte
+		padding int
+	}{
+		//padding shorter than a record
+		{"padded", []FieldSpecifier{{ID: 2, Length: 6}}, []byte{0, 0, 0, 0, 0, 1}, 2}, //packetDeltaCount
+		//padding as long as a record would be read as records
+		{"not padded", []FieldSpecifier{{ID: 4, Length: 1}}, []byte{6}, 0}, //protocolIdentifier
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := CreateFieldsDataRecord(tt.fields, []interface{}{tt.value})
+			msg := Message{
+				Header:      MessageHeader{Version: VERSION},
+				TemplateSet: []TemplateSet{CreateFieldsTemplateSet(300, tt.fields)},
+				DataSet:     []DataSet{CreateDataSet(300, record)},
+			}
+			b := Encode(msg, 0)
+			setLength := 4 + len(tt.value) + tt.padding
+			if got := int(b[len(b)-setLength+2])<<8 | int(b[len(b)-setLength+3]); got != setLength {
+				t.Errorf("data set length %d, want %d", got, setLength)
+			}
+			decoded, err := NewDecoder().Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if records := decoded.DataSet[0].Records; len(records) != 1 {
+				t.Errorf("decoded %d records, want 1", len(records))
+			}
+		})
+	}
+}
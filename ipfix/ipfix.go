@@ -1,8 +1,13 @@
 package ipfix
 
 const (
-	VERSION = uint16(10) // 4 byte
-	PADDING = uint8(0)   // 1 byte
+	VERSION                 = uint16(10)     // 4 byte
+	PADDING                 = uint8(0)       // 1 byte
+	TEMPLATE_SET_ID         = uint16(2)      // set id of template sets
+	OPTIONS_TEMPLATE_SET_ID = uint16(3)      // set id of options template sets
+	MIN_DATA_SET_ID         = uint16(256)    // data sets are numbered after their template
+	ENTERPRISE_BIT          = uint16(0x8000) // element id bit of enterprise-specific elements
+	VARIABLE_LENGTH         = uint16(65535)  // field length of variable length elements
 )
 
 type Message struct {
@@ -100,9 +105,14 @@ type FieldSpecifier struct {
 	EnterpriseNo uint32 `json:"enterprise_no"`
 }
 
+//VariableLength is the value of a field of VARIABLE_LENGTH, encoded after
+//its length
+type VariableLength []byte
+
 type DataField struct {
-	FieldID uint16      `json:"field_id"`
-	Value   interface{} `json:"value"`
+	FieldID      uint16      `json:"field_id"`
+	EnterpriseNo uint32      `json:"enterprise_no,omitempty"`
+	Value        interface{} `json:"value"`
 }
 
 //data set,containing data records
@@ -127,7 +137,7 @@ type DataField struct {
 //+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 //
 type DataSet struct {
-	Header     SetHeader   `json:"header"`
-	DataFields []DataField `json:"data_fields"`
-	padding    int         //byte count
+	Header  SetHeader     `json:"header"`
+	Records [][]DataField `json:"records"`
+	padding int           //byte count
 }
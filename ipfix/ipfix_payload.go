@@ -1,23 +1,15 @@
 package ipfix
 
 import (
+	"bytes"
 	"encoding/binary"
-	"math"
 	"math/rand"
 	"net"
-	"time"
+	"nflow-generator/clock"
+	"nflow-generator/scenario"
 )
 
 var initialTemplateId = uint16(257)
-var templateID uint16 = initialTemplateId
-
-func GetTemplateID() uint16 {
-	templateID++
-	if templateID >= math.MaxUint16-1 {
-		templateID = initialTemplateId
-	}
-	return templateID
-}
 
 //check rfc5102_model file for ids
 func GetIDs() []uint16 {
@@ -34,19 +26,42 @@ func GetIDs() []uint16 {
 	}
 }
 
-func GetVals(ips []string) []interface{} {
+//same elements as GetIDs using IPv6 addresses
+func GetIPv6IDs() []uint16 {
+	return []uint16{
+		4,  //protocolIdentifier
+		7,  //sourceTransportPort
+		27, //sourceIPv6Address
+		56, //sourceMacAddress
+		11, //destinationTransportPort
+		28, //destinationIPv6Address
+		80, //destinationMacAddress
+		21, //flowEndSysUpTime
+	}
+}
+
+//IsIPv6 tells if ips holds IPv6 addresses, an empty list defaults to IPv4
+func IsIPv6(ips []string) bool {
+	return len(ips) > 0 && net.ParseIP(ips[0]).To4() == nil
+}
+
+var srcMac, _ = net.ParseMAC("2F-F3-40-59-B0-CC")
+var dstMac, _ = net.ParseMAC("8B-83-A4-83-76-41")
+
+func GetVals(r *rand.Rand, ips []string) []interface{} {
 	var srcIp, dstIp net.IP
-	if len(ips) > 0 {
-		srcIp = net.ParseIP(ips[rand.Int()%len(ips)]).To4()
-		dstIp = net.ParseIP(ips[rand.Int()%len(ips)]).To4()
+	if IsIPv6(ips) {
+		srcIp = net.ParseIP(ips[r.Int()%len(ips)]).To16()
+		dstIp = net.ParseIP(ips[r.Int()%len(ips)]).To16()
+	} else if len(ips) > 0 {
+		srcIp = net.ParseIP(ips[r.Int()%len(ips)]).To4()
+		dstIp = net.ParseIP(ips[r.Int()%len(ips)]).To4()
 	} else {
 		srcIp = net.ParseIP("10.10.29.7").To4()
 		dstIp = net.ParseIP("10.10.29.8").To4()
 	}
 
-	t := time.Now()
-	srcMac, _ := net.ParseMAC("2F-F3-40-59-B0-CC")
-	dstMac, _ := net.ParseMAC("8B-83-A4-83-76-41")
+	t := clock.Now()
 	return []interface{}{
 		[]byte{34},
 		HostTo2Net(1234),
@@ -59,67 +74,179 @@ func GetVals(ips []string) []interface{} {
 	}
 }
 
-var initialSequenceNumber = uint32(234234234)
-var seqNum uint32 = initialSequenceNumber
+//elements added by scenarios to the default template
+func scenarioIDs() []uint16 {
+	return []uint16{
+		1, //octetDeltaCount
+		2, //packetDeltaCount
+		5, //ipClassOfService
+		6, //tcpControlBits
+	}
+}
+
+//GetFlowVals returns the values of ids for a scenario flow
+func GetFlowVals(ids []uint16, f scenario.Flow) []interface{} {
+	t := clock.Now()
+	var vals []interface{}
+	for _, id := range ids {
+		switch id {
+		case 1: //octetDeltaCount
+			vals = append(vals, HostTo8Net(f.Bytes))
+		case 2: //packetDeltaCount
+			vals = append(vals, HostTo8Net(f.Packets))
+		case 4: //protocolIdentifier
+			vals = append(vals, []byte{f.Protocol})
+		case 5: //ipClassOfService
+			vals = append(vals, []byte{f.ToS})
+		case 6: //tcpControlBits
+			vals = append(vals, HostTo2Net(uint16(f.TCPFlags)))
+		case 7: //sourceTransportPort
+			vals = append(vals, HostTo2Net(f.SrcPort))
+		case 8: //sourceIPv4Address
+			vals = append(vals, f.SrcIP.To4())
+		case 11: //destinationTransportPort
+			vals = append(vals, HostTo2Net(f.DstPort))
+		case 12: //destinationIPv4Address
+			vals = append(vals, f.DstIP.To4())
+		case 21: //flowEndSysUpTime
+			vals = append(vals, HostTo4Net(uint32(t.UnixNano())))
+		case 27: //sourceIPv6Address
+			vals = append(vals, f.SrcIP.To16())
+		case 28: //destinationIPv6Address
+			vals = append(vals, f.DstIP.To16())
+		case 56: //sourceMacAddress
+			vals = append(vals, srcMac)
+		case 80: //destinationMacAddress
+			vals = append(vals, dstMac)
+		default:
+			vals = append(vals, make([]byte, FieldLength(id)))
+		}
+	}
+	return vals
+}
 
-func GetSeqNum() uint32 {
-	seqNum++
-	if seqNum >= math.MaxUint32-1 {
-		seqNum = initialSequenceNumber
+//CreateFieldsTemplateSet announces a single template made of fields
+func CreateFieldsTemplateSet(templateID uint16, fields []FieldSpecifier) TemplateSet {
+	return TemplateSet{
+		Header: SetHeader{
+			ID:     TEMPLATE_SET_ID,
+			Length: 0,
+		},
+		Templates: []TemplateRecord{{
+			ID:         templateID,
+			FieldCount: uint16(len(fields)),
+			Fields:     fields,
+		}},
 	}
-	return seqNum
 }
 
-func GenerateNetflow(ips []string) *Message {
-	ids := GetIDs()
-	vals := GetVals(ips)
-	templateID := GetTemplateID()
+//Fields returns the field specifiers of IANA elements ids
+func Fields(ids []uint16) []FieldSpecifier {
 	var fields []FieldSpecifier
-	var dfs []DataField
 	for i := 0; i < len(ids); i++ {
 		fields = append(fields, FieldSpecifier{
 			ID:           ids[i],
-			Length:       uint16(InfoModel[ElementKey{0, ids[i]}].Type.minLen()),
+			Length:       FieldLength(ids[i]),
 			EnterpriseNo: 0,
 		})
+	}
+	return fields
+}
+
+//CreateDataSet builds a data set of templateID holding the given records
+func CreateDataSet(templateID uint16, records ...[]DataField) DataSet {
+	return DataSet{
+		Header: SetHeader{
+			ID:     templateID,
+			Length: 0,
+		},
+		Records: records,
+	}
+}
+
+//CreateFieldsDataRecord fills a data record with vals following fields order
+func CreateFieldsDataRecord(fields []FieldSpecifier, vals []interface{}) []DataField {
+	var dfs []DataField
+	for i := 0; i < len(fields); i++ {
 		dfs = append(dfs, DataField{
-			FieldID: ids[i],
-			Value:   vals[i],
+			FieldID:      fields[i].ID,
+			EnterpriseNo: fields[i].EnterpriseNo,
+			Value:        vals[i],
 		})
 	}
+	return dfs
+}
 
-	return &Message{
-		Header: MessageHeader{
-			Version:    VERSION,
-			Length:     0,
-			ExportTime: 0,
-			SequenceNo: 0,
-			DomainID:   0,
-		},
-		TemplateSet: []TemplateSet{
-			{
-				Header: SetHeader{
-					ID:     2,
-					Length: 0,
-				},
-				Templates: []TemplateRecord{{
-					ID:         templateID,
-					FieldCount: uint16(len(ids)),
-					Fields:     fields,
-				}},
-			},
-		},
-		OptionsTemplateSet: nil,
-		DataSet: []DataSet{
-			{
-				Header: SetHeader{
-					ID:     templateID,
-					Length: 0,
-				},
-				DataFields: dfs,
-			},
-		},
+//FlowStats returns the number of data records and the octets they describe
+//through octetDeltaCount
+func (msg Message) FlowStats() (int, uint64) {
+	records := 0
+	octets := uint64(0)
+	for _, dataSet := range msg.DataSet {
+		records += len(dataSet.Records)
+		for _, record := range dataSet.Records {
+			for _, field := range record {
+				if field.FieldID != 1 {
+					continue
+				}
+				switch v := field.Value.(type) {
+				case []byte: //with a reduced size encoding or not
+					n := uint64(0)
+					for _, b := range v {
+						n = n<<8 | uint64(b)
+					}
+					octets += n
+				case uint64: //decoded messages
+					octets += v
+				}
+			}
+		}
 	}
+	return records, octets
+}
+
+//FlowKey returns the values of the 5-tuple fields of a data record
+func FlowKey(record []DataField) []byte {
+	key := new(bytes.Buffer)
+	for _, field := range record {
+		switch field.FieldID {
+		case 4, 7, 8, 11, 12, 27, 28:
+			binary.Write(key, binary.BigEndian, field.Value)
+		}
+	}
+	return key.Bytes()
+}
+
+//Split distributes the data records of a message in parts messages,
+//template sets are copied in every part so that each collector learns them
+func (msg Message) Split(parts int, bucket func(record []DataField) int) []Message {
+	split := make([]Message, parts)
+	for i := range split {
+		split[i] = Message{
+			Header:             msg.Header,
+			TemplateSet:        msg.TemplateSet,
+			OptionsTemplateSet: msg.OptionsTemplateSet,
+		}
+	}
+	for _, dataSet := range msg.DataSet {
+		sets := make([]DataSet, parts)
+		for _, record := range dataSet.Records {
+			i := bucket(record)
+			sets[i].Records = append(sets[i].Records, record)
+		}
+		for i := range split {
+			if len(sets[i].Records) > 0 {
+				sets[i].Header = dataSet.Header
+				split[i].DataSet = append(split[i].DataSet, sets[i])
+			}
+		}
+	}
+	return split
+}
+
+//FieldLength returns the fixed length in bytes of an IANA element
+func FieldLength(id uint16) uint16 {
+	return uint16(InfoModel[ElementKey{0, id}].Type.minLen())
 }
 
 func HostTo2Net(n uint16) []byte {
@@ -133,3 +260,9 @@ func HostTo4Net(n uint32) []byte {
 	binary.BigEndian.PutUint32(b, n)
 	return b
 }
+
+func HostTo8Net(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
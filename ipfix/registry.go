@@ -0,0 +1,87 @@
+package ipfix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//Registry is a file of custom information elements, enterprise-specific
+//ones or IANA ones missing from InfoModel
+type Registry struct {
+	Elements []RegistryElement `json:"elements" yaml:"elements"`
+}
+
+//RegistryElement declares an information element, its type is a name of
+//FieldTypes such as 'unsigned32' or 'string'
+type RegistryElement struct {
+	EnterpriseNo uint32 `json:"enterprise" yaml:"enterprise"`
+	ID           uint16 `json:"id" yaml:"id"`
+	Name         string `json:"name" yaml:"name"`
+	Type         string `json:"type" yaml:"type"`
+}
+
+//LoadRegistry adds the elements of a yaml or json registry file to
+//InfoModel, returning the count of elements added
+func LoadRegistry(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	registry := Registry{}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		//like yaml.UnmarshalStrict, unknown keys are errors
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&registry)
+	} else {
+		err = yaml.UnmarshalStrict(content, &registry)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("can't parse ipfix registry %s: %v", path, err)
+	}
+
+	//validate every element before changing the information model
+	names := nameIndex()
+	declared := map[ElementKey]bool{}
+	for _, element := range registry.Elements {
+		key := ElementKey{element.EnterpriseNo, element.ID}
+		if element.ID == 0 || element.ID&ENTERPRISE_BIT != 0 {
+			return 0, fmt.Errorf("invalid ipfix registry %s: element %s id must be between 1 and %d", path, element.Name, ENTERPRISE_BIT-1)
+		}
+		if element.Name == "" {
+			return 0, fmt.Errorf("invalid ipfix registry %s: element %d/%d has no name", path, element.EnterpriseNo, element.ID)
+		}
+		if t, found := FieldTypes[element.Type]; !found || t == Unknown {
+			return 0, fmt.Errorf("invalid ipfix registry %s: element %s type %s is unknown", path, element.Name, element.Type)
+		}
+		//names are unique so that templates can refer to elements by name
+		if other, found := names[element.Name]; found && other != key {
+			return 0, fmt.Errorf("invalid ipfix registry %s: element name %s is already used by %d/%d", path, element.Name, other.EnterpriseNo, other.ElementID)
+		}
+		//an element keeps its name, redeclaring it with the same one is a no-op
+		if declared[key] {
+			return 0, fmt.Errorf("invalid ipfix registry %s: element %d/%d is declared twice", path, element.EnterpriseNo, element.ID)
+		}
+		if existing, found := InfoModel[key]; found && existing.Name != element.Name {
+			return 0, fmt.Errorf("invalid ipfix registry %s: element %d/%d is already named %s", path, element.EnterpriseNo, element.ID, existing.Name)
+		}
+		declared[key] = true
+		names[element.Name] = key
+	}
+
+	for _, element := range registry.Elements {
+		InfoModel[ElementKey{element.EnterpriseNo, element.ID}] = InfoElementEntry{
+			FieldID: element.ID,
+			Name:    element.Name,
+			Type:    FieldTypes[element.Type],
+		}
+	}
+	return len(registry.Elements), nil
+}
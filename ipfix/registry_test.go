@@ -0,0 +1,102 @@
+package ipfix
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeRegistry writes a registry file of a temporary directory
+func writeRegistry(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRegistry(t *testing.T) {
+	path := writeRegistry(t, "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testApplicationId, type: unsigned32}
+  - {enterprise: 32473, id: 2, name: testUrl, type: string}
+`)
+	defer delete(InfoModel, ElementKey{32473, 1})
+	defer delete(InfoModel, ElementKey{32473, 2})
+
+	count, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || InfoModel[ElementKey{32473, 2}].Type != String {
+		t.Errorf("loaded %d elements, want 2 with testUrl a string", count)
+	}
+	fields, err := ParseFields("testApplicationId,testUrl:variable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields[0].ID != 1|ENTERPRISE_BIT || fields[0].EnterpriseNo != 32473 {
+		t.Errorf("parsed %+v, want the enterprise element", fields[0])
+	}
+}
+
+func TestLoadRegistryInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"unknown yaml key", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 32473, id: 2, name: testOther, type: unsigned32, length: 4}
+`},
+		{"unknown json key", "registry.json", `{"elements": [
+  {"enterprise": 32473, "id": 1, "name": "testValid", "type": "unsigned32"},
+  {"enterprise": 32473, "id": 2, "name": "testOther", "kind": "unsigned32"}
+]}`},
+		{"unknown type", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 32473, id: 2, name: testOther, type: unsigned128}
+`},
+		{"id out of range", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 32473, id: 32768, name: testOther, type: unsigned32}
+`},
+		{"no name", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 32473, id: 2, type: unsigned32}
+`},
+		{"duplicate id", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 32473, id: 1, name: testOther, type: unsigned32}
+`},
+		{"duplicate name", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 32473, id: 2, name: testValid, type: unsigned32}
+`},
+		{"name of an iana element", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 32473, id: 2, name: octetDeltaCount, type: unsigned64}
+`},
+		{"renamed iana element", "registry.yaml", `elements:
+  - {enterprise: 32473, id: 1, name: testValid, type: unsigned32}
+  - {enterprise: 0, id: 1, name: testOctets, type: unsigned64}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := len(InfoModel)
+			octets := InfoModel[ElementKey{0, 1}]
+			if _, err := LoadRegistry(writeRegistry(t, tt.file, tt.content)); err == nil {
+				t.Fatal("loaded an invalid registry")
+			}
+			// the valid elements before the invalid one are not added either
+			if _, found := InfoModel[ElementKey{32473, 1}]; found || len(InfoModel) != size {
+				t.Errorf("information model changed from %d to %d elements", size, len(InfoModel))
+			}
+			if InfoModel[ElementKey{0, 1}] != octets {
+				t.Errorf("octetDeltaCount changed to %+v", InfoModel[ElementKey{0, 1}])
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package legacy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//sampling modes of the 2 upper bits of the header SampleInterval
+const (
+	SAMPLING_NONE          = uint8(0)
+	SAMPLING_DETERMINISTIC = uint8(1)
+	SAMPLING_RANDOM        = uint8(2)
+	MAX_SAMPLING_INTERVAL  = 0x3fff // 14 bits
+)
+
+//Exporter keeps the state of a simulated netflow v5 exporter: its engine,
+//its sampling and the sequence counter of the flows it sent
+type Exporter struct {
+	EngineType       uint8
+	EngineId         uint8
+	SamplingMode     uint8
+	SamplingInterval uint16
+	flowSequence     uint32
+}
+
+func NewExporter(engineType uint8, engineId uint8, samplingMode uint8, samplingInterval uint16) *Exporter {
+	return &Exporter{
+		EngineType:       engineType,
+		EngineId:         engineId,
+		SamplingMode:     samplingMode,
+		SamplingInterval: samplingInterval,
+	}
+}
+
+//ParseSampling reads a sampling mode and interval written 'deterministic:N'
+//or 'random:N', an empty string means unsampled
+func ParseSampling(s string) (uint8, uint16, error) {
+	if s == "" {
+		return SAMPLING_NONE, 0, nil
+	}
+	mode, interval := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		mode, interval = s[:i], s[i+1:]
+	}
+	n, err := strconv.Atoi(interval)
+	if err != nil || n < 1 || n > MAX_SAMPLING_INTERVAL {
+		return 0, 0, fmt.Errorf("sampling %s is not valid, the interval must be between 1 and %d", s, MAX_SAMPLING_INTERVAL)
+	}
+	switch mode {
+	case "deterministic":
+		return SAMPLING_DETERMINISTIC, uint16(n), nil
+	case "random":
+		return SAMPLING_RANDOM, uint16(n), nil
+	}
+	return 0, 0, fmt.Errorf("sampling %s is not valid, use 'deterministic:N' or 'random:N'", s)
+}
+
+//SampleInterval packs a sampling mode and interval like the header field,
+//the mode in the first 2 bits and the interval in the remaining 14 bits
+func SampleInterval(mode uint8, interval uint16) uint16 {
+	return uint16(mode)<<14 | interval&MAX_SAMPLING_INTERVAL
+}
+
+//Encode a packet of the exporter, setting the header engine and sampling
+//fields and its flow sequence, the count of flows sent before this packet
+func (e *Exporter) Encode(data Netflow) []byte {
+	data.Header.EngineType = e.EngineType
+	data.Header.EngineId = e.EngineId
+	data.Header.SampleInterval = SampleInterval(e.SamplingMode, e.SamplingInterval)
+	data.Header.FlowSequence = e.flowSequence
+	e.flowSequence += uint32(len(data.Records))
+	return BuildNFlowPayload(data)
+}
@@ -0,0 +1,64 @@
+package legacy
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestParseSampling(t *testing.T) {
+	tests := []struct {
+		sampling string
+		mode     uint8
+		interval uint16
+		err      bool
+	}{
+		{"", SAMPLING_NONE, 0, false},
+		{"deterministic:1", SAMPLING_DETERMINISTIC, 1, false},
+		{"random:100", SAMPLING_RANDOM, 100, false},
+		{"random:16383", SAMPLING_RANDOM, 0x3fff, false},
+		{"random:16384", 0, 0, true},
+		{"deterministic:0", 0, 0, true},
+		{"deterministic:-1", 0, 0, true},
+		{"deterministic", 0, 0, true},
+		{"systematic:10", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sampling, func(t *testing.T) {
+			mode, interval, err := ParseSampling(tt.sampling)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error %v", err, tt.err)
+			}
+			if mode != tt.mode || interval != tt.interval {
+				t.Errorf("parsed mode %d interval %d, want %d and %d", mode, interval, tt.mode, tt.interval)
+			}
+		})
+	}
+}
+
+func TestEncodeSampleInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     uint8
+		interval uint16
+		want     uint16
+	}{
+		{"unsampled", SAMPLING_NONE, 0, 0},
+		{"deterministic", SAMPLING_DETERMINISTIC, 100, 0x4064},
+		{"random", SAMPLING_RANDOM, 100, 0x8064},
+		{"largest interval", SAMPLING_RANDOM, 0x3fff, 0xbfff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewExporter(1, 2, tt.mode, tt.interval)
+			b := e.Encode(GenerateNetflow(rand.New(rand.NewSource(1)), 16, nil, false))
+			//mode in the 2 first bits and interval in the 14 others, after the engine type and id
+			if b[20] != 1 || b[21] != 2 {
+				t.Errorf("engine type %d and id %d, want 1 and 2", b[20], b[21])
+			}
+			if got := binary.BigEndian.Uint16(b[22:]); got != tt.want {
+				t.Errorf("sampling interval field %#04x, want %#04x", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,21 +3,16 @@ package legacy
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"net"
+	"nflow-generator/clock"
+	"nflow-generator/scenario"
 	"time"
 )
 
-// Start time for this instance, used to compute sysUptime
-var StartTime = time.Now().UnixNano()
-
-// current sysUptime in msec - recalculated in CreateNFlowHeader()
-var sysUptime uint32 = 0
-
-// Counter of flow packets that have been sent
-var flowSequence uint32 = 0
-
 const (
 	FTP_PORT        = 21
 	SSH_PORT        = 22
@@ -94,28 +89,78 @@ func BuildNFlowPayload(data Netflow) []byte {
 	return buffer.Bytes()
 }
 
-var falseIndex = false
+//Unmarshall a netflow v5 packet into NetflowData
+func ParseNFlowPayload(b []byte) (Netflow, error) {
+	var data Netflow
+	buffer := bytes.NewReader(b)
+	if err := binary.Read(buffer, binary.BigEndian, &data.Header); err != nil {
+		return data, fmt.Errorf("reading netflow header failed: %w", err)
+	}
+	if data.Header.Version != 5 {
+		return data, fmt.Errorf("netflow version %d is not valid", data.Header.Version)
+	}
+	data.Records = make([]NetflowPayload, data.Header.FlowCount)
+	if err := binary.Read(buffer, binary.BigEndian, data.Records); err != nil {
+		return data, fmt.Errorf("reading %d netflow records failed: %w", data.Header.FlowCount, err)
+	}
+	return data, nil
+}
+
+//FlowStats returns the number of flow records and the octets they describe
+func (data Netflow) FlowStats() (int, uint64) {
+	octets := uint64(0)
+	for _, record := range data.Records {
+		octets += uint64(record.NumOctets)
+	}
+	return len(data.Records), octets
+}
+
+//FlowKey returns the 5-tuple of a record: addresses, ports and protocol
+func (record NetflowPayload) FlowKey() []byte {
+	key := make([]byte, 13)
+	binary.BigEndian.PutUint32(key[0:], record.SrcIP)
+	binary.BigEndian.PutUint32(key[4:], record.DstIP)
+	binary.BigEndian.PutUint16(key[8:], record.SrcPort)
+	binary.BigEndian.PutUint16(key[10:], record.DstPort)
+	key[12] = record.IpProtocol
+	return key
+}
+
+//Split distributes the records of a packet in parts packets sharing its header
+func (data Netflow) Split(parts int, bucket func(record NetflowPayload) int) []Netflow {
+	split := make([]Netflow, parts)
+	for _, record := range data.Records {
+		i := bucket(record)
+		split[i].Records = append(split[i].Records, record)
+	}
+	for i := range split {
+		split[i].Header = data.Header
+		split[i].Header.FlowCount = uint16(len(split[i].Records))
+	}
+	return split
+}
 
 //Generate a netflow packet w/ user-defined record count
-func GenerateNetflow(recordCount int, ips []string, fi bool) Netflow {
+func GenerateNetflow(r *rand.Rand, recordCount int, ips []string, fi bool) Netflow {
 	data := new(Netflow)
 	header := CreateNFlowHeader(recordCount)
-	falseIndex = fi
 	var records []NetflowPayload
 	if recordCount == 8 {
 		// overwrite payload to add some variations for traffic spikes.
-		records = CreateVariablePayload(recordCount)
+		records = CreateVariablePayload(r, recordCount)
 	} else {
-		records = CreateNFlowPayload(recordCount)
+		records = CreateNFlowPayload(r, recordCount)
+	}
+	for i := range records {
+		SetFalseIndex(&records[i], fi)
 	}
 
 	//override ips from list if specified
 	if len(ips) > 0 {
-		rand.Seed(time.Now().Unix())
 		for i := 0; i < len(records); i++ {
-			records[i].SrcIP = IPtoUint32(ips[rand.Int()%len(ips)])
-			records[i].DstIP = IPtoUint32(ips[rand.Int()%len(ips)])
-			records[i].NextHopIP = IPtoUint32(ips[rand.Int()%len(ips)])
+			records[i].SrcIP = IPtoUint32(ips[r.Int()%len(ips)])
+			records[i].DstIP = IPtoUint32(ips[r.Int()%len(ips)])
+			records[i].NextHopIP = IPtoUint32(ips[r.Int()%len(ips)])
 		}
 	}
 
@@ -124,73 +169,104 @@ func GenerateNetflow(recordCount int, ips []string, fi bool) Netflow {
 	return *data
 }
 
-//Generate and initialize netflow header
+//Generate a netflow packet w/ user-defined record count drawn from a scenario
+func GenerateScenarioNetflow(r *rand.Rand, recordCount int, s *scenario.Scenario, ips []string, fi bool) Netflow {
+	data := new(Netflow)
+	data.Header = CreateNFlowHeader(recordCount)
+	for i := 0; i < recordCount; i++ {
+		record := CreateScenarioFlow(r, s.Next(r, ips))
+		SetFalseIndex(&record, fi)
+		data.Records = append(data.Records, record)
+	}
+	return *data
+}
+
+//Set false SNMP interface indexes of 1 or 2 following the order of the
+//record addresses when fi is set, the default interfaces are zero
+func SetFalseIndex(payload *NetflowPayload, fi bool) {
+	switch {
+	case !fi:
+		payload.SnmpInIndex = 0
+		payload.SnmpOutIndex = 0
+	case payload.SrcIP > payload.DstIP:
+		payload.SnmpInIndex = 1
+		payload.SnmpOutIndex = 2
+	default:
+		payload.SnmpInIndex = 2
+		payload.SnmpOutIndex = 1
+	}
+}
+
+//current sysUptime in msec
+func sysUptime(t int64) uint32 {
+	return uint32((t-clock.Start().UnixNano())/int64(time.Millisecond)) + 1000
+}
+
+//Generate and initialize netflow header, the flow sequence, engine and
+//sampling fields are set by the Exporter encoding the packet
 func CreateNFlowHeader(recordCount int) NetflowHeader {
 
-	t := time.Now().UnixNano()
+	t := clock.Now().UnixNano()
 	sec := t / int64(time.Second)
 	nsec := t - sec*int64(time.Second)
-	sysUptime = uint32((t-StartTime)/int64(time.Millisecond)) + 1000
-	flowSequence++
 
 	// log.Infof("Time: %d; Seconds: %d; Nanoseconds: %d\n", t, sec, nsec)
-	// log.Infof("StartTime: %d; sysUptime: %d", StartTime, sysUptime)
-	// log.Infof("FlowSequence %d", flowSequence)
+	// log.Infof("StartTime: %d; sysUptime: %d", clock.Start().UnixNano(), sysUptime(t))
 
 	h := new(NetflowHeader)
 	h.Version = 5
 	h.FlowCount = uint16(recordCount)
-	h.SysUptime = sysUptime
+	h.SysUptime = sysUptime(t)
 	h.UnixSec = uint32(sec)
 	h.UnixMsec = uint32(nsec)
-	h.FlowSequence = flowSequence
+	h.FlowSequence = 0
 	h.EngineType = 1
 	h.EngineId = 0
 	h.SampleInterval = 0
 	return *h
 }
 
-func CreateVariablePayload(recordCount int) []NetflowPayload {
+func CreateVariablePayload(r *rand.Rand, recordCount int) []NetflowPayload {
 	payload := make([]NetflowPayload, recordCount)
 
 	for i := 0; i < recordCount; i++ {
-		payload[0] = CreateHttpFlow()
-		payload[1] = CreateHttpsFlow()
-		payload[2] = CreateHttpAltFlow()
-		payload[3] = CreateDnsFlow()
-		payload[5] = CreateNtpFlow()
-		payload[6] = CreateImapsFlow()
-		payload[7] = CreateMySqlFlow()
+		payload[0] = CreateHttpFlow(r)
+		payload[1] = CreateHttpsFlow(r)
+		payload[2] = CreateHttpAltFlow(r)
+		payload[3] = CreateDnsFlow(r)
+		payload[5] = CreateNtpFlow(r)
+		payload[6] = CreateImapsFlow(r)
+		payload[7] = CreateMySqlFlow(r)
 	}
 
 	return payload
 }
 
-func CreateNFlowPayload(recordCount int) []NetflowPayload {
+func CreateNFlowPayload(r *rand.Rand, recordCount int) []NetflowPayload {
 	payload := make([]NetflowPayload, recordCount)
 	for i := 0; i < recordCount; i++ {
-		payload[0] = CreateHttpFlow()
-		payload[1] = CreateHttpsFlow()
-		payload[2] = CreateHttpAltFlow()
-		payload[3] = CreateDnsFlow()
-		payload[4] = CreateIcmpFlow()
-		payload[5] = CreateNtpFlow()
-		payload[6] = CreateImapsFlow()
-		payload[7] = CreateMySqlFlow()
-		payload[8] = CreateRandomFlow()
-		payload[9] = CreateSshFlow()
-		payload[10] = CreateP2pFlow()
-		payload[11] = CreateBitorrentFlow()
-		payload[12] = CreateFTPFlow()
-		payload[13] = CreateSnmpFlow()
-		payload[14] = CreateIcmpFlow()
-		payload[15] = CreateRandomFlow()
+		payload[0] = CreateHttpFlow(r)
+		payload[1] = CreateHttpsFlow(r)
+		payload[2] = CreateHttpAltFlow(r)
+		payload[3] = CreateDnsFlow(r)
+		payload[4] = CreateIcmpFlow(r)
+		payload[5] = CreateNtpFlow(r)
+		payload[6] = CreateImapsFlow(r)
+		payload[7] = CreateMySqlFlow(r)
+		payload[8] = CreateRandomFlow(r)
+		payload[9] = CreateSshFlow(r)
+		payload[10] = CreateP2pFlow(r)
+		payload[11] = CreateBitorrentFlow(r)
+		payload[12] = CreateFTPFlow(r)
+		payload[13] = CreateSnmpFlow(r)
+		payload[14] = CreateIcmpFlow(r)
+		payload[15] = CreateRandomFlow(r)
 	}
 	return payload
 }
 
 //Initialize netflow record with random data
-func CreateIcmpFlow() NetflowPayload {
+func CreateIcmpFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 	payload.SrcIP = IPtoUint32("172.16.50.10")
 	payload.DstIP = IPtoUint32("132.12.130.10")
@@ -209,12 +285,12 @@ func CreateIcmpFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_SM, 1, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_SM, 1, r.Intn(32))
 	return *payload
 }
 
 //Initialize netflow record with random data
-func CreateHttpFlow() NetflowPayload {
+func CreateHttpFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 	payload.SrcIP = IPtoUint32("112.10.20.10")
 	payload.DstIP = IPtoUint32("172.30.190.10")
@@ -233,12 +309,12 @@ func CreateHttpFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
 	return *payload
 }
 
 //Initialize netflow record with random data
-func CreateSnmpFlow() NetflowPayload {
+func CreateSnmpFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 	payload.SrcIP = IPtoUint32("112.10.20.10")
 	payload.DstIP = IPtoUint32("172.30.190.10")
@@ -257,11 +333,11 @@ func CreateSnmpFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 17, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 17, r.Intn(32))
 	return *payload
 }
 
-func CreateFTPFlow() NetflowPayload {
+func CreateFTPFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 	payload.SrcIP = IPtoUint32("112.10.100.10")
 	payload.DstIP = IPtoUint32("192.168.120.10")
@@ -280,11 +356,11 @@ func CreateFTPFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
 	return *payload
 }
 
-func CreateNtpFlow() NetflowPayload {
+func CreateNtpFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 	payload.SrcIP = IPtoUint32("247.104.20.202")
 	payload.DstIP = IPtoUint32("10.12.190.10")
@@ -304,11 +380,11 @@ func CreateNtpFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(32)
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 17, 32)
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 17, 32)
 	return *payload
 }
 
-func CreateP2pFlow() NetflowPayload {
+func CreateP2pFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 	payload.SrcIP = IPtoUint32("247.104.20.202")
 	payload.DstIP = IPtoUint32("10.12.190.10")
@@ -329,11 +405,11 @@ func CreateP2pFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(32)
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 17, 32)
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 17, 32)
 	return *payload
 }
 
-func CreateBitorrentFlow() NetflowPayload {
+func CreateBitorrentFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
 	payload.SrcIP = IPtoUint32("192.168.20.202")
@@ -355,11 +431,11 @@ func CreateBitorrentFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(32)
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 17, 32)
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 17, 32)
 	return *payload
 }
 
-func CreateSshFlow() NetflowPayload {
+func CreateSshFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
 	payload.SrcIP = IPtoUint32("172.30.20.102")
@@ -381,11 +457,11 @@ func CreateSshFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
 	return *payload
 }
 
-func CreateHttpsFlow() NetflowPayload {
+func CreateHttpsFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
 	payload.SrcIP = IPtoUint32("192.168.20.10")
@@ -407,11 +483,11 @@ func CreateHttpsFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
 	return *payload
 }
 
-func CreateHttpAltFlow() NetflowPayload {
+func CreateHttpAltFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
 	payload.SrcIP = IPtoUint32("10.10.20.122")
@@ -433,11 +509,11 @@ func CreateHttpAltFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
 	return *payload
 }
 
-func CreateDnsFlow() NetflowPayload {
+func CreateDnsFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
 	payload.SrcIP = IPtoUint32("59.220.158.122")
@@ -459,11 +535,11 @@ func CreateDnsFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 17, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 17, r.Intn(32))
 	return *payload
 }
 
-func CreateImapsFlow() NetflowPayload {
+func CreateImapsFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
 	payload.SrcIP = IPtoUint32("172.30.20.102")
@@ -485,11 +561,11 @@ func CreateImapsFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
 	return *payload
 }
 
-func CreateMySqlFlow() NetflowPayload {
+func CreateMySqlFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
 	payload.SrcIP = IPtoUint32("10.154.20.12")
@@ -511,18 +587,18 @@ func CreateMySqlFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
 	return *payload
 }
 
-func CreateRandomFlow() NetflowPayload {
+func CreateRandomFlow(r *rand.Rand) NetflowPayload {
 	payload := new(NetflowPayload)
 
-	payload.SrcIP = rand.Uint32()
-	payload.DstIP = rand.Uint32()
-	payload.NextHopIP = rand.Uint32()
-	payload.SrcPort = genRandUint16(UINT16_MAX)
-	payload.DstPort = genRandUint16(UINT16_MAX)
+	payload.SrcIP = r.Uint32()
+	payload.DstIP = r.Uint32()
+	payload.NextHopIP = r.Uint32()
+	payload.SrcPort = genRandUint16(r, UINT16_MAX)
+	payload.DstPort = genRandUint16(r, UINT16_MAX)
 	// payload.SnmpInIndex = genRandUint16(UINT16_MAX)
 	// payload.SnmpOutIndex = genRandUint16(UINT16_MAX)
 	// payload.NumPackets = genRandUint32(PAYLOAD_AVG_MD)
@@ -537,12 +613,31 @@ func CreateRandomFlow() NetflowPayload {
 	// payload.SrcPrefixMask = uint8(rand.Intn(32))
 	// payload.DstPrefixMask = uint8(rand.Intn(32))
 	// payload.Padding2 = 0
-	FillCommonFields(payload, PAYLOAD_AVG_MD, 6, rand.Intn(32))
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, 6, r.Intn(32))
+	return *payload
+}
+
+//Initialize netflow record from a scenario flow, IPv6 addresses are left empty
+func CreateScenarioFlow(r *rand.Rand, f scenario.Flow) NetflowPayload {
+	payload := new(NetflowPayload)
+
+	if f.IPVersion == 4 {
+		payload.SrcIP = binary.BigEndian.Uint32(f.SrcIP.To4())
+		payload.DstIP = binary.BigEndian.Uint32(f.DstIP.To4())
+	}
+	payload.SrcPort = f.SrcPort
+	payload.DstPort = f.DstPort
+	FillCommonFields(r, payload, PAYLOAD_AVG_MD, int(f.Protocol), r.Intn(32))
+	payload.NumPackets = clampUint32(f.Packets)
+	payload.NumOctets = clampUint32(f.Bytes)
+	payload.TcpFlags = f.TCPFlags
+	payload.IpTos = f.ToS
 	return *payload
 }
 
 // patch up the common fields of the packets
 func FillCommonFields(
+	r *rand.Rand,
 	payload *NetflowPayload,
 	numPktOct int,
 	ipProtocol int,
@@ -556,44 +651,36 @@ func FillCommonFields(
 	// payload.DstPort = uint16(MYSQL_PORT)
 	// payload.SnmpInIndex = genRandUint16(UINT16_MAX)
 	// payload.SnmpOutIndex = genRandUint16(UINT16_MAX)
-	payload.NumPackets = genRandUint32(numPktOct)
-	payload.NumOctets = genRandUint32(numPktOct)
+	payload.NumPackets = genRandUint32(r, numPktOct)
+	payload.NumOctets = genRandUint32(r, numPktOct)
 	// payload.SysUptimeStart = rand.Uint32()
 	// payload.SysUptimeEnd = rand.Uint32()
 	payload.Padding1 = 0
 	payload.IpProtocol = uint8(ipProtocol)
 	payload.IpTos = 0
-	payload.SrcAsNumber = genRandUint16(UINT16_MAX)
-	payload.DstAsNumber = genRandUint16(UINT16_MAX)
+	payload.SrcAsNumber = genRandUint16(r, UINT16_MAX)
+	payload.DstAsNumber = genRandUint16(r, UINT16_MAX)
 
 	payload.SrcPrefixMask = uint8(srcPrefixMask)
-	payload.DstPrefixMask = uint8(rand.Intn(32))
+	payload.DstPrefixMask = uint8(r.Intn(32))
 	payload.Padding2 = 0
 
-	// now handle computed values
-	if !falseIndex { // default interfaces are zero
-		payload.SnmpInIndex = 0
-		payload.SnmpOutIndex = 0
-	} else if payload.SrcIP > payload.DstIP { // false-index
-		payload.SnmpInIndex = 1
-		payload.SnmpOutIndex = 2
-	} else {
-		payload.SnmpInIndex = 2
-		payload.SnmpOutIndex = 1
-	}
+	// now handle computed values, interfaces are set by SetFalseIndex
+	payload.SnmpInIndex = 0
+	payload.SnmpOutIndex = 0
 
-	uptime := int(sysUptime)
-	payload.SysUptimeEnd = uint32(uptime - RandomNum(10, 500))
-	payload.SysUptimeStart = payload.SysUptimeEnd - uint32(RandomNum(10, 500))
+	uptime := int(sysUptime(clock.Now().UnixNano()))
+	payload.SysUptimeEnd = uint32(uptime - RandomNum(r, 10, 500))
+	payload.SysUptimeStart = payload.SysUptimeEnd - uint32(RandomNum(r, 10, 500))
 
 	// log.Infof("S&D : %x %x %d, %d", payload.SrcIP, payload.DstIP, payload.DstPort, payload.SnmpInIndex)
-	// log.Infof("Time: %d %d %d", sysUptime, payload.SysUptimeStart, payload.SysUptimeEnd)
+	// log.Infof("Time: %d %d %d", uptime, payload.SysUptimeStart, payload.SysUptimeEnd)
 
 	return *payload
 }
 
-func genRandUint16(max int) uint16 {
-	return uint16(rand.Intn(max))
+func genRandUint16(r *rand.Rand, max int) uint16 {
+	return uint16(r.Intn(max))
 }
 
 func IPtoUint32(s string) uint32 {
@@ -601,10 +688,17 @@ func IPtoUint32(s string) uint32 {
 	return binary.BigEndian.Uint32(ip.To4())
 }
 
-func genRandUint32(max int) uint32 {
-	return uint32(rand.Intn(max))
+func clampUint32(n uint64) uint32 {
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
+
+func genRandUint32(r *rand.Rand, max int) uint32 {
+	return uint32(r.Intn(max))
 }
 
-func RandomNum(min, max int) int {
-	return rand.Intn(max-min) + min
+func RandomNum(r *rand.Rand, min, max int) int {
+	return r.Intn(max-min) + min
 }
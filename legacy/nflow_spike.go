@@ -1,40 +1,43 @@
 package legacy
 
-import "log"
+import (
+	"log"
+	"math/rand"
+)
 
 //Generate a netflow packet w/ user-defined record count
-func GenerateSpike(spikeProto string) Netflow {
+func GenerateSpike(r *rand.Rand, spikeProto string) Netflow {
 	data := new(Netflow)
 	data.Header = CreateNFlowHeader(1)
-	data.Records = spikeFlowPayload(spikeProto)
+	data.Records = spikeFlowPayload(r, spikeProto)
 	return *data
 }
 
-func spikeFlowPayload(spikeProto string) []NetflowPayload {
+func spikeFlowPayload(r *rand.Rand, spikeProto string) []NetflowPayload {
 	payload := make([]NetflowPayload, 1)
 	switch spikeProto {
 	case "ssh":
-		payload[0] = CreateSshFlow()
+		payload[0] = CreateSshFlow(r)
 	case "ftp":
-		payload[0] = CreateFTPFlow()
+		payload[0] = CreateFTPFlow(r)
 	case "http":
-		payload[0] = CreateHttpFlow()
+		payload[0] = CreateHttpFlow(r)
 	case "https":
-		payload[0] = CreateHttpsFlow()
+		payload[0] = CreateHttpsFlow(r)
 	case "ntp":
-		payload[0] = CreateNtpFlow()
+		payload[0] = CreateNtpFlow(r)
 	case "snmp":
-		payload[0] = CreateSnmpFlow()
+		payload[0] = CreateSnmpFlow(r)
 	case "imaps":
-		payload[0] = CreateImapsFlow()
+		payload[0] = CreateImapsFlow(r)
 	case "mysql":
-		payload[0] = CreateMySqlFlow()
+		payload[0] = CreateMySqlFlow(r)
 	case "https_alt":
-		payload[0] = CreateHttpAltFlow()
+		payload[0] = CreateHttpAltFlow(r)
 	case "p2p":
-		payload[0] = CreateP2pFlow()
+		payload[0] = CreateP2pFlow(r)
 	case "bittorrent":
-		payload[0] = CreateBitorrentFlow()
+		payload[0] = CreateBitorrentFlow(r)
 	default:
 		log.Fatalf("protocol option %s is not valid, see --help for options", spikeProto)
 	}
@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
-	"errors"
+	"crypto/tls"
 	"fmt"
+	"math"
 	"math/rand"
 	"net"
+	"nflow-generator/clock"
 	"nflow-generator/ipfix"
 	"nflow-generator/legacy"
 	"nflow-generator/pb"
+	"nflow-generator/scenario"
+	"nflow-generator/sflow"
+	"nflow-generator/v9"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jessevdk/go-flags"
-	"github.com/netobserv/netobserv-ebpf-agent/pkg/grpc"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
 	"github.com/seancfoley/ipaddress-go/ipaddr"
 )
@@ -37,27 +44,97 @@ const (
 )
 
 var opts struct {
-	CollectorIPs  string `short:"t" long:"targets" description:"target ip address(es) the netflow collector(s), comma separated"`
-	CollectorPort int    `short:"p" long:"port" description:"port number of the target netflow collector. Default 2055"`
-	SpikeProto    string `long:"spike" description:"run a second thread generating a spike for the specified protocol"`
-	FalseIndex    bool   `long:"false-index" description:"generate false SNMP interface indexes, otherwise set to 0"`
-	IPs           string `short:"i" long:"ips" description:"use specific list of ips, comma separated"`
-	Type          string `long:"type" description:"use 'legacy' for netflow v5, 'ipfix' for v10 or 'pb' for fake ebpf agent. Default is legacy"`
-	Sleep         bool   `short:"s" long:"sleep" description:"enable random sleep time"`
-	MinSleep      int    `long:"minsleep" description:"min sleep time. Default: 50"`
-	MaxSleep      int    `long:"maxsleep" description:"max sleep time. Default: 1000"`
-	RateSleep     int    `long:"ratesleep" description:"sleep time between each rate log. Default: 10"`
-	Concurrency   int    `long:"concurrency" description:"number of threads to run in parallel"`
-	Help          bool   `short:"h" long:"help" description:"show nflow-generator help"`
+	CollectorIPs     string        `short:"t" long:"targets" description:"target ip address(es) the netflow collector(s), comma separated"`
+	CollectorPort    int           `short:"p" long:"port" description:"port number of the target netflow collector. Default 2055"`
+	SpikeProto       string        `long:"spike" description:"run a second thread generating a spike for the specified protocol"`
+	FalseIndex       bool          `long:"false-index" description:"generate false SNMP interface indexes, otherwise set to 0"`
+	IPs              string        `short:"i" long:"ips" description:"use specific list of ips, comma separated"`
+	Scenario         string        `long:"scenario" description:"yaml or json file describing the flow profiles of the traffic mix"`
+	IPVersion        string        `long:"ip-version" description:"ip version of generated flows: '4', '6' or 'dual'. Default: 4"`
+	Type             string        `long:"type" description:"use 'legacy' for netflow v5, 'v9' for netflow v9, 'ipfix' for v10, 'sflow' for sflow v5 or 'pb' for fake ebpf agent. Default is legacy"`
+	Sleep            bool          `short:"s" long:"sleep" description:"enable random sleep time"`
+	MinSleep         int           `long:"minsleep" description:"min sleep time. Default: 50"`
+	MaxSleep         int           `long:"maxsleep" description:"max sleep time. Default: 1000"`
+	RateSleep        int           `long:"ratesleep" description:"sleep time between each rate log. Default: 10"`
+	Concurrency      int           `long:"concurrency" description:"number of threads to run in parallel"`
+	Exporters        int           `long:"exporters" description:"number of simulated exporters spread across the threads, each with its own sockets, engine id, observation domain and sequence numbers. Default: one per thread"`
+	SourceIPs        string        `long:"source-ips" description:"local ip addresses the exporters send from, comma separated, assigned to the exporters in turn. Default: picked by the system"`
+	DomainID         int           `long:"domain-id" description:"ipfix observation domain and netflow v9 source id of the first exporter, counting up for the next ones. Default: 0"`
+	TemplateInterval time.Duration `long:"template-interval" description:"interval between ipfix template refreshes, 0 to disable. Default: 60s"`
+	TemplatePackets  int           `long:"template-packets" description:"refresh ipfix templates every N packets, 0 to disable. Default: 0"`
+	IPFIXRegistry    string        `long:"ipfix-registry" description:"yaml or json file of custom ipfix information elements, enterprise-specific or missing from the IANA registry"`
+	IPFIXFields      string        `long:"ipfix-fields" description:"ipfix template as comma separated information element names, each optionally followed by ':' and a field length or ':variable'"`
+	MTU              int           `long:"mtu" description:"maximum ipfix message or sflow datagram size used to batch records. Default: 1400"`
+	SamplingRate     int           `long:"sampling-rate" description:"sflow sampling rate, one packet out of N. Default: 1000"`
+	EngineType       int           `long:"engine-type" description:"netflow v5 engine type. Default: 1"`
+	EngineID         int           `long:"engine-id" description:"netflow v5 engine id of the first exporter, counting up for the next ones. Default: 0"`
+	V5Sampling       string        `long:"v5-sampling" description:"netflow v5 and v9 sampling mode and interval: 'deterministic:N' or 'random:N', one packet out of N. Default: unsampled"`
+	BatchSize        int           `long:"batch-size" description:"number of records per pb send. Default: 16"`
+	Rate             string        `long:"rate" description:"target rate shared by all threads, in flows (fps) or packets (pps) per second, e.g. 50000fps or 10kpps"`
+	Bandwidth        string        `long:"bandwidth" description:"target bandwidth of the traffic described by the flows, e.g. 200Mbps"`
+	Seed             int64         `long:"seed" description:"seed of the random generators, also enables a simulated clock for reproducible streams. Default: random"`
+	StartTime        string        `long:"start-time" description:"start time of the simulated clock used with --seed, RFC3339 format. Default: 2022-01-01T00:00:00Z"`
+	Duration         time.Duration `long:"duration" description:"stop after the given duration, e.g. 5m. Default: run until interrupted"`
+	Count            string        `long:"count" description:"stop after sending N packets or flows, e.g. 1000 or 50kflows. Default: run until interrupted"`
+	Transport        string        `long:"transport" description:"transport of the ipfix exports: 'udp', 'tcp' or 'tls'. Default: udp"`
+	TLSCA            string        `long:"tls-ca" description:"ca certificate file verifying the tls peer. Default: system roots"`
+	TLSCert          string        `long:"tls-cert" description:"certificate file presented to the tls peer"`
+	TLSKey           string        `long:"tls-key" description:"private key file of --tls-cert"`
+	TLSInsecure      bool          `long:"tls-insecure" description:"do not verify the certificate of the tls collector"`
+	Output           string        `long:"output" description:"write the datagrams to a file instead of sending them: 'pcap:/path/file.pcap', or publish pb records to kafka: 'kafka://broker:9092/topic'"`
+	KafkaEncoding    string        `long:"kafka-encoding" description:"encoding of the kafka messages: 'protobuf' or 'json'. Default: protobuf"`
+	KafkaKey         string        `long:"kafka-key" description:"partition key of the kafka messages: 'none', 'flow', 'src', 'dst' or 'interface'. Default: none"`
+	Distribution     string        `long:"distribution" description:"distribution of the packets across targets: 'round-robin', 'replicate', 'weighted' or 'hash'. Default: round-robin"`
+	Weights          string        `long:"weights" description:"weights of the targets with --distribution weighted, comma separated. Default: 1 for each target"`
+	OnError          string        `long:"on-error" description:"policy on send errors: 'fail', 'retry' or 'skip'. Default: fail"`
+	Retries          int           `long:"retries" description:"max retries of a packet with --on-error retry. Default: 5"`
+	RetryBackoff     time.Duration `long:"retry-backoff" description:"initial backoff between retries, doubled up to 10s. Default: 100ms"`
+	MetricsAddr      string        `long:"metrics-addr" description:"address of the prometheus metrics endpoint, e.g. :9090. Default: disabled"`
+	Verify           string        `long:"verify" description:"compare the sent flows with the records of a sink once stopped: 'file:/path/records.jsonl', 'kafka:/path/topic.dump' or an http url"`
+	VerifyTimeout    time.Duration `long:"verify-timeout" description:"time to wait for the sink to hold every sent flow. Default: 10s"`
+	Help             bool          `short:"h" long:"help" description:"show nflow-generator help"`
 }
 
 var err error
-var ips []string
-var collectorAddrs []*net.UDPAddr
-var loopCount float64 = 0
+var ips4 []string
+var ips6 []string
+var flowScenario *scenario.Scenario
+var ipfixTemplate []ipfix.FieldSpecifier
+var defaultIPv6s = []string{"2001:db8:10:29::7", "2001:db8:10:29::8"}
+var targets []string // collector addresses, or the kafka output url
+var stats *statistics
+var flowPacer, packetPacer, bandwidthPacer *pacer
+var paced bool
+var sendBudget *budget
+var packetDistribution *distribution
+var pcapOutput *pcapFile
+var kafkaOutput *kafkaPublisher
+var replaying bool
+var sourceIPs []net.IP
+var tlsConfig *tls.Config
+var v5SamplingMode uint8
+var v5SamplingInterval uint16
+var flowVerifier *verifier
 
 func main() {
-	_, err = flags.Parse(&opts)
+	// defaults of the options where 0 is a valid value
+	opts.EngineType = 1
+	opts.TemplateInterval = 60 * time.Second
+	replayOpts.Speed = 1
+
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err = parser.AddCommand("replay", "replay a pcap file",
+		"Replay the udp datagrams of a pcap file to the targets", &replayOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = parser.AddCommand("collect", "collect exports",
+		"Listen for netflow v5 and ipfix exports, decode them and count or print their records", &collectOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = parser.Parse()
 
 	if err != nil {
 		showUsage()
@@ -69,11 +146,31 @@ func main() {
 		os.Exit(1)
 	}
 
-	if opts.CollectorIPs == "" {
+	collecting := parser.Active != nil && parser.Active.Name == "collect"
+	kafkaOutputSet := strings.HasPrefix(opts.Output, "kafka://")
+	if opts.CollectorIPs == "" && !collecting && !kafkaOutputSet {
 		showUsage()
 		os.Exit(1)
 	}
 
+	replaying = parser.Active != nil && parser.Active.Name == "replay"
+	if replaying {
+		if opts.Type == "pb" {
+			log.Fatal("replay sends udp datagrams, --type pb is not supported")
+		}
+		if opts.Rate != "" || opts.Bandwidth != "" {
+			log.Warn("rate and bandwidth are ignored by replay, use --speed")
+			opts.Rate, opts.Bandwidth = "", ""
+		}
+		if opts.Distribution == hashed {
+			log.Fatal("replayed datagrams can't be split by flow, use another --distribution")
+		}
+		if replayOpts.Speed < 0 {
+			log.Fatal("replay speed must be positive")
+		}
+		opts.Concurrency = 1
+	}
+
 	if opts.CollectorPort == 0 {
 		opts.CollectorPort = 2055
 	}
@@ -90,8 +187,51 @@ func main() {
 		opts.RateSleep = 10
 	}
 
+	switch opts.Transport {
+	case "", udpTransport:
+		opts.Transport = udpTransport
+	case tcpTransport, tlsTransport:
+		if !collecting && opts.Type != "ipfix" {
+			log.Fatalf("%s transport only carries ipfix, use --type ipfix", opts.Transport)
+		}
+		if opts.Output != "" || replaying {
+			log.Fatalf("%s transport sends generated ipfix messages, --output and replay are not supported", opts.Transport)
+		}
+	default:
+		log.Fatalf("transport %s is not valid, use '%s', '%s' or '%s'", opts.Transport, udpTransport, tcpTransport, tlsTransport)
+	}
+	if opts.Transport == tlsTransport {
+		tlsConfig, err = newTLSConfig(opts.TLSCA, opts.TLSCert, opts.TLSKey, opts.TLSInsecure)
+		if err != nil {
+			log.Fatal("Error loading tls configuration: ", err)
+		}
+	}
+
+	// the collector names the elements of the registry too
+	if opts.IPFIXRegistry != "" {
+		count, err := ipfix.LoadRegistry(opts.IPFIXRegistry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("loaded %d ipfix information elements from %s", count, opts.IPFIXRegistry)
+	}
+
+	if collecting {
+		ctx, stop := runContext()
+		defer stop()
+		collect(ctx)
+		return
+	}
+
+	if kafkaOutputSet && opts.CollectorIPs != "" {
+		log.Warn("targets are ignored when publishing to kafka")
+		opts.CollectorIPs = ""
+	}
 	splittedCollectorIPsString := strings.Split(opts.CollectorIPs, ",")
 	for _, ip := range splittedCollectorIPsString {
+		if ip == "" {
+			continue
+		}
 		if !strings.Contains(ip, ":") {
 			ip = fmt.Sprintf("%s:%d", ip, opts.CollectorPort)
 		}
@@ -103,7 +243,7 @@ func main() {
 			log.Fatal(err)
 		}
 
-		collectorAddrs = append(collectorAddrs, collectorAddr)
+		targets = append(targets, collectorAddr.String())
 	}
 
 	if len(opts.IPs) > 0 {
@@ -112,115 +252,558 @@ func main() {
 		for _, ip := range splittedIPsString {
 			block := ipaddr.NewIPAddressString(ip).GetAddress()
 			for i := block.Iterator(); i.HasNext(); {
-				ip := i.Next().GetNetIPAddr().String()
-				ips = append(ips, ip)
+				addr := i.Next()
+				ip := addr.GetNetIPAddr().String()
+				if addr.IsIPv6() {
+					ips6 = append(ips6, ip)
+				} else {
+					ips4 = append(ips4, ip)
+				}
 				log.Infof("%s", ip)
 			}
 		}
 	}
 
+	if opts.Scenario != "" {
+		flowScenario, err = scenario.Load(opts.Scenario)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("using scenario %s with %d profiles", opts.Scenario, len(flowScenario.Profiles))
+	}
+
+	if opts.IPFIXFields != "" {
+		if opts.Type != "ipfix" {
+			log.Fatal("ipfix fields only apply to ipfix exports, use --type ipfix")
+		}
+		if flowScenario != nil {
+			log.Fatal("ipfix fields and scenario can't be combined, the scenario sets its own template")
+		}
+		ipfixTemplate, err = ipfix.ParseFields(opts.IPFIXFields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("using ipfix template of %d fields, records of at least %d bytes", len(ipfixTemplate), ipfix.FieldsRecordLength(ipfixTemplate))
+	}
+
+	checkIPVersion()
+
 	if opts.Concurrency == 0 {
 		opts.Concurrency = 1
 	}
 
-	rand.Seed(time.Now().UnixNano())
-	for i := 0; i < opts.Concurrency; i++ {
-		go loopFlows()
+	if opts.Exporters == 0 {
+		opts.Exporters = opts.Concurrency
+	}
+	if opts.Exporters < opts.Concurrency {
+		log.Warnf("%d exporters for %d threads, running %d threads", opts.Exporters, opts.Concurrency, opts.Exporters)
+		opts.Concurrency = opts.Exporters
 	}
 
-	loopRate()
-}
+	if opts.SourceIPs != "" {
+		sourceIPs, err = parseSourceIPs(opts.SourceIPs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if opts.Type == "pb" {
+			log.Warn("source ips only apply to udp exports, grpc connections are not bound")
+		}
+	}
 
-func loopFlows() {
-	i := rand.Int() % len(collectorAddrs)
+	// each exporter gets the next observation domain and engine id
+	if opts.DomainID < 0 || int64(opts.DomainID)+int64(opts.Exporters-1) > math.MaxUint32 {
+		log.Fatalf("ipfix observation domains and v9 source ids of the %d exporters must be between 0 and %d", opts.Exporters, uint32(math.MaxUint32))
+	}
 
-	var grpcConn *grpc.ClientConnection
-	var flows []*pbflow.Record
+	if opts.Transport != udpTransport {
+		// templates are only sent at the start of tcp and tls sessions - RFC7011 section 8.4
+		opts.TemplateInterval, opts.TemplatePackets = 0, 0
+	}
 
-	var udpConn *net.UDPConn
-	var byteArray []byte
+	if opts.MTU == 0 {
+		opts.MTU = ipfix.DefaultMTU
+		if opts.Type == "sflow" {
+			opts.MTU = sflow.DefaultMTU
+		}
+	}
+	checkMTU()
 
-	target := fmt.Sprintf("%s:%d", collectorAddrs[i].IP.String(), collectorAddrs[i].Port)
-	if opts.Type == "pb" {
-		log.Infof("checking grpc target %s ...", target)
+	if opts.SamplingRate == 0 {
+		opts.SamplingRate = sflow.DefaultSamplingRate
+	}
 
-		grpcConn, err = grpc.ConnectClient(target)
+	if opts.EngineType < 0 || opts.EngineType > 255 || opts.EngineID < 0 || opts.EngineID+opts.Exporters-1 > 255 {
+		log.Fatalf("netflow v5 engine type and the engine ids of the %d exporters must be between 0 and 255", opts.Exporters)
+	}
+
+	v5SamplingMode, v5SamplingInterval, err = legacy.ParseSampling(opts.V5Sampling)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = pb.DefaultBatchSize
+	}
+
+	if opts.Rate != "" {
+		rate, unit, err := parseRate(opts.Rate, "fps", "fps", "pps")
 		if err != nil {
-			log.Fatal("Error resolving grpcExporter: ", err)
+			log.Fatal(err)
 		}
-		log.Infof("grpc target %s ok !", target)
-	} else {
-		log.Infof("checking udp target %s ...", target)
+		if unit == "pps" {
+			packetPacer = newPacer(rate)
+		} else {
+			flowPacer = newPacer(rate)
+		}
+		paced = true
+		log.Infof("target rate: %.0f %s", rate, unit)
+	}
+
+	if opts.Bandwidth != "" {
+		bandwidth, _, err := parseRate(opts.Bandwidth, "bps", "bps")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if opts.Type == "ipfix" && flowScenario == nil && !ipfix.HasOctetCount(ipfixTemplate) {
+			log.Warn("ipfix template has no octet count, use --scenario or add octetDeltaCount to --ipfix-fields to pace on bandwidth")
+		}
+		bandwidthPacer = newPacer(bandwidth)
+		paced = true
+		log.Infof("target bandwidth: %.0f bps of described traffic", bandwidth)
+	}
+
+	if kafkaOutputSet {
+		if opts.Type != "pb" || replaying {
+			log.Fatal("kafka output publishes pb records, use --type pb")
+		}
+		if opts.KafkaEncoding == "" {
+			opts.KafkaEncoding = "protobuf"
+		}
+		if opts.KafkaKey == "" {
+			opts.KafkaKey = "none"
+		}
+		kafkaOutput, err = newKafkaPublisher(opts.Output, opts.KafkaEncoding, opts.KafkaKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		targets = []string{kafkaOutput.name}
+		log.Infof("publishing %s records to %s", opts.KafkaEncoding, kafkaOutput.name)
+	} else if opts.Output != "" {
+		path := strings.TrimPrefix(opts.Output, "pcap:")
+		if path == opts.Output || path == "" {
+			log.Fatalf("output %s is not valid, use 'pcap:/path/file.pcap' or 'kafka://broker:9092/topic'", opts.Output)
+		}
+		if opts.Type == "pb" {
+			log.Fatal("pcap output only holds udp datagrams, use --type legacy, v9, ipfix or sflow")
+		}
+		if opts.Exporters > pcapMaxExporters {
+			log.Fatalf("pcap output gives each exporter its own source port, at most %d exporters", pcapMaxExporters)
+		}
+		pcapOutput, err = createPcapFile(path)
+		if err != nil {
+			log.Fatal("Error creating pcap file: ", err)
+		}
+		log.Infof("writing datagrams to %s", path)
+	}
+
+	if opts.Distribution == "" {
+		opts.Distribution = roundRobin
+	}
+	packetDistribution, err = newDistribution(opts.Distribution, len(targets), opts.Weights)
+	if err != nil {
+		log.Fatal("Error parsing distribution: ", err)
+	}
+	if opts.Weights != "" && opts.Distribution != weighted {
+		log.Warn("weights are ignored, use --distribution weighted")
+	}
+
+	switch opts.OnError {
+	case "":
+		opts.OnError = "fail"
+	case "fail", "retry", "skip":
+	default:
+		log.Fatalf("error policy %s is not valid, use 'fail', 'retry' or 'skip'", opts.OnError)
+	}
+
+	if opts.Retries == 0 {
+		opts.Retries = 5
+	}
+
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = 100 * time.Millisecond
+	}
 
-		addr, err := net.ResolveUDPAddr("udp", target)
+	if opts.Count != "" {
+		count, unit, err := parseRate(opts.Count, "packets", "packets", "flows")
 		if err != nil {
-			log.Fatal("Error resolving udp address: ", err)
+			log.Fatal("Error parsing count: ", err)
+		}
+		sendBudget = newBudget(uint64(count), unit == "flows")
+		log.Infof("stopping after %.0f %s", count, unit)
+	}
+
+	if opts.Verify != "" {
+		if replaying || opts.Type == "sflow" {
+			log.Fatal("verify needs flow records, use --type legacy, v9, ipfix or pb")
+		}
+		// the fingerprints of all the sent flows are kept until the end
+		if opts.Count == "" && opts.Duration == 0 {
+			log.Fatal("verify keeps every sent flow in memory, bound the run with --count or --duration")
 		}
-		udpConn, err = net.DialUDP("udp", nil, addr)
+		if opts.VerifyTimeout == 0 {
+			opts.VerifyTimeout = 10 * time.Second
+		}
+		if opts.Verify == "kafka:"+kafkaMemoryBrokers && (kafkaOutput == nil || kafkaOutput.memory == nil) {
+			log.Fatal("verify kafka:memory reads the kafka stand-in, use --output kafka://memory/topic")
+		}
+		flowVerifier, err = newVerifier(opts.Verify, opts.VerifyTimeout)
 		if err != nil {
-			log.Fatal("Error dialing udp address: ", err)
+			log.Fatal(err)
+		}
+	}
+
+	if paced && opts.Sleep {
+		log.Warn("random sleep is disabled when a rate or bandwidth is set")
+	}
+
+	if opts.Seed != 0 {
+		start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		if opts.StartTime != "" {
+			start, err = time.Parse(time.RFC3339, opts.StartTime)
+			if err != nil {
+				log.Fatal("Error parsing start time: ", err)
+			}
+		}
+		clock.Simulate(start, time.Millisecond)
+		log.Infof("using seed %d with a simulated clock starting at %s", opts.Seed, start.Format(time.RFC3339))
+	} else {
+		opts.Seed = time.Now().UnixNano()
+		log.Infof("using random seed %d", opts.Seed)
+	}
+
+	stats = newStatistics(opts.Concurrency, targets)
+
+	if opts.MetricsAddr != "" {
+		exporterType := opts.Type
+		if exporterType == "" {
+			exporterType = "legacy"
+		}
+		if replaying {
+			exporterType = "replay"
 		}
-		log.Infof("udp target %s ok !", target)
+		stats.metrics = newMetrics(exporterType)
+		stats.metrics.init(targets)
+		stats.metrics.serve(opts.MetricsAddr)
 	}
 
-	for {
-		n := legacy.RandomNum(opts.MinSleep, opts.MaxSleep)
+	ctx, stop := runContext()
+	defer stop()
+
+	var wg sync.WaitGroup
+	var replayed bool
+	if replaying {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replayed = replayPcap(ctx, rand.New(rand.NewSource(opts.Seed)))
+		}()
+	} else {
+		for i := 0; i < opts.Concurrency; i++ {
+			wg.Add(1)
+			// every worker owns its random source so a seed gives a reproducible stream
+			go func(worker int, r *rand.Rand) {
+				defer wg.Done()
+				loopFlows(ctx, worker, r)
+			}(i, rand.New(rand.NewSource(opts.Seed+int64(i))))
+		}
+	}
+
+	reportCtx, stopReport := context.WithCancel(ctx)
+	go stats.report(reportCtx, time.Duration(opts.RateSleep)*time.Second)
+
+	wg.Wait()
+	stopReport()
+
+	if pcapOutput != nil {
+		if err := pcapOutput.close(); err != nil {
+			log.Fatal("Error writing pcap file: ", err)
+		}
+	}
+	if kafkaOutput != nil {
+		if err := kafkaOutput.close(); err != nil {
+			log.Error("Error closing kafka writer: ", err)
+		}
+	}
+
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		log.Infof("duration of %s reached, stopping", opts.Duration)
+	case context.Canceled:
+		log.Info("interrupted, stopping")
+	default:
+		if replayed {
+			log.Infof("end of %s reached, stopping", replayOpts.Args.File)
+		} else {
+			log.Infof("count of %s reached, stopping", opts.Count)
+		}
+	}
+	stats.logSummary()
+
+	if flowVerifier != nil {
+		// restore the default signal handling while waiting for the sink
+		stop()
+		if !flowVerifier.verify(context.Background()) {
+			os.Exit(1)
+		}
+	}
+}
 
+// runContext returns a context done on interrupt or once --duration is
+// reached, a second signal kills the process
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if opts.Duration <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// loopFlows generates and sends packets until the context is done or the
+// --count budget is spent
+func loopFlows(ctx context.Context, worker int, r *rand.Rand) {
+	exporters := workerExporters(worker)
+	for _, e := range exporters {
+		defer e.close()
+	}
+
+	// the exporters of the worker send packets in turn
+	for next := 0; ctx.Err() == nil; next++ {
+		e := exporters[next%len(exporters)]
+		n := legacy.RandomNum(r, opts.MinSleep, opts.MaxSleep)
+		targets := packetDistribution.pick(r)
+
+		var packets []packet
 		switch opts.Type {
+		case "v9":
+			var msg *v9.Message
+			if flowScenario != nil {
+				msg = v9.GenerateScenarioNetflow(r, 16, flowScenario, pickIPs(r), opts.FalseIndex)
+			} else {
+				msg = v9.GenerateNetflow(r, 16, pickIPs(r), opts.FalseIndex)
+			}
+			packets = packetDistribution.v9Packets(e.v9, *msg, targets)
 		case "ipfix":
-			msg := ipfix.GenerateNetflow(ips)
-			byteArray = ipfix.Encode(*msg, ipfix.GetSeqNum())
+			msg := packetDistribution.ipfixExporter(e.ipfix, targets).GenerateNetflow(r, e.domainID, pickIPs(r))
+			packets = packetDistribution.ipfixPackets(e.ipfix, *msg, targets)
+		case "sflow":
+			datagram := packetDistribution.sflowExporter(e.sflow, targets).GenerateDatagram(r, pickIPs(r), opts.FalseIndex)
+			packets = packetDistribution.sflowPackets(e.sflow, *datagram, targets)
 		case "pb":
-			flows = pb.GenerateRecords(ips)
+			var flows []*pbflow.Record
+			if flowScenario != nil {
+				flows = pb.GenerateScenarioRecords(r, flowScenario, pickIPs(r), opts.BatchSize)
+			} else {
+				flows = pb.GenerateRecords(r, pickIPs(r), opts.BatchSize)
+			}
+			packets = packetDistribution.pbPackets(flows, targets)
 		default:
 			// add spike data
 			if opts.SpikeProto != "" {
-				legacy.GenerateSpike(opts.SpikeProto)
+				legacy.GenerateSpike(r, opts.SpikeProto)
 			}
 			recordCount := 16
 			if n > 900 {
 				recordCount = 8
 			}
-			data := legacy.GenerateNetflow(recordCount, ips, opts.FalseIndex)
-			byteArray = legacy.BuildNFlowPayload(data)
+			var data legacy.Netflow
+			if flowScenario != nil {
+				data = legacy.GenerateScenarioNetflow(r, recordCount, flowScenario, ips4, opts.FalseIndex)
+			} else {
+				data = legacy.GenerateNetflow(r, recordCount, ips4, opts.FalseIndex)
+			}
+			packets = packetDistribution.legacyPackets(e.legacy, data, targets)
 		}
 
-		if grpcConn != nil {
-			_, err = grpcConn.Client().Send(context.TODO(), &pbflow.Records{
-				Entries: flows,
-			})
-		} else if udpConn != nil {
-			_, err = udpConn.Write(byteArray)
-		} else {
-			err = errors.New("either grpc or udp connection should be set")
-		}
+		for _, p := range packets {
+			if sendBudget != nil && !sendBudget.take(p.flowCount) {
+				return
+			}
 
-		if err != nil {
-			log.Fatal("Error connecting to the target collector: ", err)
+			if flowPacer != nil && !flowPacer.Wait(ctx, float64(p.flowCount)) {
+				return
+			}
+			if packetPacer != nil && !packetPacer.Wait(ctx, 1) {
+				return
+			}
+			if bandwidthPacer != nil && !bandwidthPacer.Wait(ctx, float64(p.octets*8)) {
+				return
+			}
+
+			sent, ok := sendPacket(ctx, worker, e.senders[p.target], p.byteArray, p.flows, p.flowCount)
+			if !ok {
+				return
+			}
+			// flows dropped by the generator are not expected in the sink
+			if sent && flowVerifier != nil {
+				flowVerifier.add(p.fingerprints)
+			}
 		}
 
-		if opts.Sleep {
+		if opts.Sleep && !paced {
 			// add some periodic spike data
 			if n < 150 {
 				sleepInt := time.Duration(3000)
-				time.Sleep(sleepInt * time.Millisecond)
+				sleep(ctx, sleepInt*time.Millisecond)
 			}
 			sleepInt := time.Duration(n)
-			time.Sleep(sleepInt * time.Millisecond)
+			sleep(ctx, sleepInt*time.Millisecond)
 		}
 
-		loopCount++
+		clock.Tick()
 	}
 }
 
-func loopRate() {
-	for {
-		loopCount = 0
+// connectTargets returns a sender per collector for an exporter, sending
+// from the local ip when set or writing to the pcap output when set
+func connectTargets(id int, local net.IP) []*sender {
+	protocol := opts.Transport
+	if opts.Type == "pb" {
+		protocol = "grpc"
+	}
 
-		time.Sleep(time.Duration(opts.RateSleep) * time.Second)
+	senders := make([]*sender, len(targets))
+	for i, target := range targets {
+		if kafkaOutput != nil {
+			senders[i] = &sender{target: target, kafka: kafkaOutput}
+			continue
+		}
+		if pcapOutput != nil {
+			conn, err := newPcapSender(id, local, target, pcapOutput)
+			if err != nil {
+				log.Fatal("Error resolving udp address: ", err)
+			}
+			senders[i] = conn
+			continue
+		}
 
-		rate := loopCount / float64(opts.RateSleep)
-		log.Infof("Current rate is: %.1f calls per seconds", rate)
+		// only the first exporters of the workers are logged
+		logf := log.Infof
+		if id >= opts.Concurrency {
+			logf = log.Debugf
+		}
+		logf("checking %s target %s ...", protocol, target)
+		conn, err := newSender(target, opts.Type == "pb", opts.Transport, local)
+		switch {
+		case err == nil:
+			logf("%s target %s ok !", protocol, target)
+		case opts.OnError == "fail":
+			log.Fatalf("Error connecting to %s target: %v", protocol, err)
+		default:
+			// the sender is dialed again on its first send, following --on-error
+			log.Warnf("%s target %s is not reachable yet: %v", protocol, target, err)
+			conn.close()
+			conn.failing = true
+		}
+		senders[i] = conn
+	}
+	return senders
+}
+
+// validate --mtu against the smallest message of the exporter type and the
+// largest payload of its transport
+func checkMTU() {
+	var min int
+	switch opts.Type {
+	case "ipfix":
+		e := ipfix.NewExporter(0, 0, opts.MTU)
+		e.Scenario = flowScenario
+		e.Fields = ipfixTemplate
+		min = e.MinMTU()
+	case "sflow":
+		e := sflow.NewExporter(0, 0, opts.MTU)
+		for _, ip := range sourceIPs {
+			// exporters bound to an ipv6 source announce a longer agent address
+			if ip.To4() == nil {
+				e.AgentAddress = ip
+			}
+		}
+		min = e.MinMTU()
+	default:
+		return
+	}
+	max := maxUDPPayload
+	if opts.Transport != udpTransport {
+		max = ipfix.MaxMTU
+	}
+	if opts.MTU < min || opts.MTU > max {
+		log.Fatalf("--mtu %d must be between %d, the size of a message of one record, and %d for %s", opts.MTU, min, max, opts.Transport)
+	}
+}
+
+// validate --ip-version against the exporter type and the specified ips
+func checkIPVersion() {
+	isLegacy := opts.Type == "" || opts.Type == "legacy"
+	switch opts.IPVersion {
+	case "", "4":
+		opts.IPVersion = "4"
+		if len(ips6) > 0 {
+			if len(ips4) == 0 {
+				log.Fatal("no ipv4 address in specified ips, use --ip-version 6 or dual")
+			}
+			log.Warn("ipv6 addresses are ignored, use --ip-version 6 or dual")
+		}
+	case "6":
+		if isLegacy {
+			log.Fatal("netflow v5 does not support ipv6, use --type v9, ipfix or pb")
+		}
+		if len(ips4) > 0 {
+			if len(ips6) == 0 {
+				log.Fatal("no ipv6 address in specified ips, use --ip-version 4 or dual")
+			}
+			log.Warn("ipv4 addresses are ignored, use --ip-version 4 or dual")
+		}
+	case "dual":
+		if isLegacy {
+			log.Warn("netflow v5 only carries ipv4, ipv6 flows are not generated")
+		}
+	default:
+		log.Fatalf("ip version %s is not valid, use '4', '6' or 'dual'", opts.IPVersion)
+	}
+
+	if opts.IPVersion != "4" && len(ips6) == 0 {
+		ips6 = defaultIPv6s
+	}
+
+	if flowScenario != nil {
+		if opts.IPVersion != "6" && !flowScenario.Supports(4) {
+			log.Fatal("scenario has no ipv4 profile, use --ip-version 6")
+		}
+		if opts.IPVersion != "4" && !isLegacy && !flowScenario.Supports(6) {
+			log.Fatal("scenario has no ipv6 profile, use --ip-version 4")
+		}
+	}
+}
+
+// ips of the family to use for the next packet, dual stack picks one randomly
+func pickIPs(r *rand.Rand) []string {
+	switch opts.IPVersion {
+	case "6":
+		return ips6
+	case "dual":
+		if r.Intn(2) == 0 {
+			return ips4
+		}
+		return ips6
+	default:
+		return ips4
 	}
 }
 
@@ -251,12 +834,77 @@ Application Options:
         bittorrent - generates udp/6682
   --false-index generate a false snmp index values of 1 or 2. The default is 0. (Optional)
   -i, --ips use specific list of ips, comma separated (Optional)
-  --type use 'legacy' for netflow v5, 'ipfix' for v10 or 'pb' for fake ebpf agent. Default is legacy
+  --scenario yaml or json file describing the flow profiles of the traffic mix, see examples/scenario.yaml
+  --ip-version ip version of generated flows: '4', '6' or 'dual'. Default: 4
+    netflow v5 only carries ipv4, 'dual' generates ipv4 flows only for legacy type
+  --type use 'legacy' for netflow v5, 'v9' for netflow v9, 'ipfix' for v10, 'sflow' for sflow v5 or 'pb' for fake ebpf agent. Default is legacy
   -s, --sleep enable random sleep time
 	--minsleep min sleep time. Default: 50
 	--maxsleep max sleep time. Default: 1000
 	--ratesleep sleep time between each rate log. Default: 10
 	--concurrency number of threads to run in parallel
+	--exporters number of simulated exporters spread across the threads. Default: one per thread
+	  each exporter sends from its own sockets with its own engine id, observation domain and sequence numbers
+	--source-ips local ip addresses the exporters send from, comma separated, assigned in turn. Default: picked by the system
+	--domain-id ipfix observation domain and netflow v9 source id of the first exporter, counting up for the next ones. Default: 0
+	--rate target rate shared by all threads, in flows (fps) or packets (pps) per second, e.g. 50000fps or 10kpps
+	--bandwidth target bandwidth of the traffic described by the flows, e.g. 200Mbps
+	  random sleep is disabled when a rate or bandwidth is set
+	--seed seed of the random generators, also enables a simulated clock for reproducible streams. Default: random
+	  with --concurrency 1, a given seed and configuration produce a byte identical stream
+	--start-time start time of the simulated clock used with --seed, RFC3339 format. Default: 2022-01-01T00:00:00Z
+	--duration stop after the given duration, e.g. 5m. Default: run until interrupted
+	--count stop after sending N packets or flows, e.g. 1000 or 50kflows. Default: run until interrupted
+	  the last packet can exceed a count of flows, a summary is logged on exit
+	--output write the datagrams to a file instead of sending them: 'pcap:/path/file.pcap'
+	  datagrams are wrapped in ethernet, ip and udp headers addressed to the targets
+	  or publish pb records to kafka, one message per record: 'kafka://broker1:9092,broker2:9092/topic'
+	  'kafka://memory/topic' publishes to an in-process stand-in of 4 partitions for local checks, keeping the last 100000 messages of each
+	--kafka-encoding encoding of the kafka messages: 'protobuf' or 'json'. Default: protobuf
+	--kafka-key partition key of the kafka messages: 'none', 'flow', 'src', 'dst' or 'interface'. Default: none
+	  none spreads the messages round-robin, the other keys hash the 5-tuple, addresses or interface
+	--distribution distribution of the packets across targets. Default: round-robin
+	  round-robin sends each packet to the next target
+	  replicate sends each packet to every target
+	  weighted sends each packet to a target picked according to --weights
+	  hash splits the flows of each packet so that a 5-tuple always goes to the same target
+	--weights weights of the targets with --distribution weighted, comma separated. Default: 1 for each target
+	--on-error policy on send errors: 'fail', 'retry' or 'skip'. Default: fail
+	  retry and skip close the udp socket or grpc connection and dial it again
+	  a collector not reachable at startup is dialed again on the first send
+	--retries max retries of a packet with --on-error retry, the packet is dropped after. Default: 5
+	--retry-backoff initial backoff between retries, doubled up to 10s. Default: 100ms
+	--metrics-addr address of the prometheus metrics endpoint, e.g. :9090. Default: disabled
+	  exposes packets, records, bytes, errors and send latency per type and target on /metrics
+	--verify compare the sent flows with the records of a sink once stopped, exit code 1 on mismatch
+	  'file:/path/records.jsonl' json lines, 'kafka:/path/topic.dump' messages of a topic one per line, or an http url
+	  'kafka:memory' reads the messages of the kafka stand-in
+	  reports missing, duplicated, altered and unexpected flows, for legacy, v9, ipfix and pb types
+	  needs --count or --duration as every sent flow is kept in memory
+	--verify-timeout time to wait for the sink to hold every sent flow. Default: 10s
+	--template-interval interval between ipfix template refreshes, 0 to disable. Default: 60s
+	--template-packets refresh ipfix templates every N packets, 0 to disable. Default: 0
+	--ipfix-registry yaml or json file of custom ipfix information elements, see examples/ipfix-registry.yaml
+	  enterprise-specific elements can then be named in --ipfix-fields and are named by collect
+	--ipfix-fields ipfix template as comma separated information element names, e.g. 'octetDeltaCount:4,sourceIPv4Address,applicationName:32'
+	  an element name can be followed by ':' and a field length: a reduced size for integers, the length of strings and octet arrays (Default: 16)
+	  or ':variable' to send strings and octet arrays with a variable length, e.g. 'interfaceName:variable'
+	  addresses, ports, counters and timestamps describe a consistent flow, the other elements get random values of their type
+	--transport transport of the ipfix exports: 'udp', 'tcp' or 'tls'. Default: udp
+	  tcp and tls keep a connection per exporter and collector, dialed again on errors or, following --on-error, when down at startup
+	  templates are sent once at the start of each session, --template-interval and --template-packets are ignored
+	--tls-ca ca certificate file verifying the tls collector. Default: system roots
+	--tls-cert --tls-key certificate and private key files presented to the collector for mutual authentication
+	--tls-insecure do not verify the certificate of the tls collector
+	--mtu maximum ipfix message or sflow datagram size used to batch records. Default: 1400
+	  from the size of a message of one record up to 65507 over udp, 65535 over tcp and tls
+	--sampling-rate sflow sampling rate, one packet out of N. Default: 1000
+	--engine-type netflow v5 engine type. Default: 1
+	--engine-id netflow v5 engine id of the first exporter, counting up for the next ones. Default: 0
+	--v5-sampling netflow v5 and v9 sampling mode and interval: 'deterministic:N' or 'random:N'. Default: unsampled
+	  netflow v9 exports announce it in their sampling options data
+	  the mode and the interval, up to 16383, are encoded in the 2 and 14 bits of the header sample interval
+	--batch-size number of records per pb send. Default: 16
 
 Example Usage:
 
@@ -269,12 +917,90 @@ Example Usage:
     -generate default flows between ips 172.16.86.1, 172.16.86.2, 172.16.86.3 to device 172.16.86.138, port 9995
     ./nflow-generator -t 172.16.86.138 -p 9995 -i 172.16.86.1,172.16.86.2,172.16.86.3
 
+    -generate ipv6 flows between ips 2001:db8::1, 2001:db8::2 to device 172.16.86.138, port 4739
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --ip-version 6 -i 2001:db8::1,2001:db8::2
+
+    -generate ipfix flows at 50000 flows per second using 4 threads
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --rate 50000fps --concurrency 4
+
+    -generate sflow v5 datagrams sampling one packet out of 512
+    ./nflow-generator -t 172.16.86.138 -p 6343 --type sflow --sampling-rate 512
+
+    -generate netflow v5 exports of engines 10 to 13 randomly sampling one packet out of 100
+    ./nflow-generator -t 172.16.86.138 -p 9995 --exporters 4 --engine-id 10 --v5-sampling random:100
+
+    -simulate 200 ipfix exporters of observation domains 100 to 299 sending from 2 local addresses
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --exporters 200 --concurrency 4 --domain-id 100 --source-ips 10.0.0.1,10.0.0.2
+
+    -write 1000 ipfix packets to a pcap file, reproducible with a seed
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --count 1000 --seed 42 --output pcap:ipfix.pcap
+
+    -replay the netflow exports of a capture twice as fast, as if they were sent now
+    ./nflow-generator -t 172.16.86.138 -p 2055 replay --capture-port 2055 --speed 2 --rewrite capture.pcap
+
+    -collect the ipfix exports of a local generator and print their records
+    ./nflow-generator -p 4739 collect --print
+
+    -send ipfix over tls to a collector requiring exporter certificates
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --transport tls --tls-ca ca.pem --tls-cert exporter.pem --tls-key exporter.key
+
+    -send ipfix records of a custom template, with 4 bytes octet counts and 32 bytes application names
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --ipfix-fields octetDeltaCount:4,packetDeltaCount,sourceIPv4Address,destinationIPv4Address,protocolIdentifier,flowEndMilliseconds,applicationName:32
+
+    -send enterprise-specific elements declared in a registry, and collect them with the same registry
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --ipfix-registry examples/ipfix-registry.yaml --ipfix-fields octetDeltaCount,sourceIPv4Address,destinationIPv4Address,exampleApplicationId,exampleUrl:variable
+    ./nflow-generator -p 4739 --ipfix-registry examples/ipfix-registry.yaml collect --print
+
+    -check that a collector writing json lines to records.jsonl got every ipfix flow
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --count 1000 --verify file:records.jsonl
+
+    -send batches of 100 agent records to a flowlogs-pipeline grpc ingester
+    ./nflow-generator -t 172.16.86.138 -p 9999 --type pb --batch-size 100
+
+    -publish agent records as json to a kafka topic, keyed by 5-tuple
+    ./nflow-generator --type pb --output kafka://172.16.86.138:9092/network-flows --kafka-encoding json --kafka-key flow
+
+    -send every packet to both collectors of an HA pair
+    ./nflow-generator -t 172.16.86.138,172.16.86.139 -p 9995 --distribution replicate
+
+    -send a burst of 100000 ipfix flows then exit
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --count 100kflows --rate 20000fps
+
+    -keep generating when the collector restarts, dropping the packets sent meanwhile
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --on-error skip
+
+    -generate ipfix flows and expose prometheus metrics on port 9090
+    ./nflow-generator -t 172.16.86.138 -p 4739 --type ipfix --metrics-addr :9090
+
     -generate default flows along with a spike in the specified protocol:
     ./nflow-generator -t 172.16.86.138 -p 9995 -s ssh
 
     -generate default flows with "false index" settings for snmp interfaces 
     ./nflow-generator -t 172.16.86.138 -p 9995 -f
 
+Replay Command:
+  main [OPTIONS] replay [replay OPTIONS] FILE
+
+  Replay the udp datagrams of a pcap file to the targets, paced like they were captured.
+  Application options apply except --type, --rate, --bandwidth, --concurrency and --exporters.
+
+  --capture-port udp destination port of the captured exports to replay. Default: all udp datagrams
+  --speed replay speed relative to the capture, 0 to replay as fast as possible. Default: 1
+  --rewrite rewrite export timestamps to now and sequence numbers to start from 0
+    applies to netflow v5, v9 and ipfix exports, other datagrams are replayed unchanged
+
+Collect Command:
+  main [OPTIONS] collect [collect OPTIONS]
+
+  Listen for netflow v5 and ipfix exports, decode them and log the received counts every --ratesleep seconds.
+  Application options apply except the generation ones, --duration stops the collector.
+
+  --listen udp address to listen on. Default: the port of --port on all addresses
+  --print print every decoded record as a json line on stdout
+    fields are named after the ipfix information elements, v5 records included
+  with --transport tcp or tls, ipfix sessions are accepted on --listen instead
+    the collector presents --tls-cert and --tls-key, and requires exporter certificates signed by --tls-ca when set
+
 Help Options:
   -h, --help    Show this help message
   `
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var collectOpts struct {
+	Listen string `long:"listen" description:"udp address to listen on, or tcp address with --transport tcp or tls. Default: the port of --port on all addresses"`
+	Print  bool   `long:"print" description:"print every decoded record as a json line on stdout"`
+}
+
+// collectSession is the state kept per exporter, identified by its udp
+// source address like an ipfix transport session
+type collectSession struct {
+	decoder     *ipfix.Decoder
+	nextSeqNums map[uint32]uint32 // expected ipfix sequence number by observation domain
+	nextFlowSeq map[uint32]uint32 // expected netflow v5 flow sequence by engine type and id
+}
+
+// collector decodes the netflow v5 and ipfix exports it receives, the
+// counters are read atomically by the periodic report
+type collector struct {
+	mutex    sync.Mutex // held by the tcp and tls sessions while receiving
+	sessions map[string]*collectSession
+	v5       counters
+	ipfix    counters
+	ignored  uint64 // datagrams of other versions
+	missing  uint64 // ipfix data sets received before their template
+	lost     uint64 // records missing according to sequence numbers
+	out      *json.Encoder
+}
+
+func newCollector() *collector {
+	c := &collector{
+		sessions: map[string]*collectSession{},
+	}
+	if collectOpts.Print {
+		c.out = json.NewEncoder(os.Stdout)
+	}
+	return c
+}
+
+// collect receives exports until the context is done
+func collect(ctx context.Context) {
+	addr := collectOpts.Listen
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", opts.CollectorPort)
+	}
+
+	c := newCollector()
+	go c.report(ctx, time.Duration(opts.RateSleep)*time.Second)
+	if opts.Transport == udpTransport {
+		c.collectDatagrams(ctx, addr)
+	} else {
+		c.collectStreams(ctx, addr)
+	}
+	c.logSummary()
+}
+
+// collectDatagrams receives udp exports, each source address is a session
+func (c *collector) collectDatagrams(ctx context.Context, addr string) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		log.Fatal("Error listening: ", err)
+	}
+	log.Infof("collecting netflow v5 and ipfix exports on %s", conn.LocalAddr())
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Fatal("Error receiving: ", err)
+		}
+		c.receive(src.String(), buf[:n])
+	}
+}
+
+// collectStreams accepts ipfix exports over tcp or tls, each connection is
+// a session of its own
+func (c *collector) collectStreams(ctx context.Context, addr string) {
+	var listener net.Listener
+	var err error
+	if opts.Transport == tlsTransport {
+		if len(tlsConfig.Certificates) == 0 {
+			log.Fatal("tls collector needs a certificate, use --tls-cert and --tls-key")
+		}
+		// exporters are authenticated when a ca is given - RFC7011 section 11.4
+		if tlsConfig.ClientCAs != nil {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		listener, err = tls.Listen("tcp", addr, tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		log.Fatal("Error listening: ", err)
+	}
+	log.Infof("collecting ipfix exports over %s on %s", opts.Transport, listener.Addr())
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Fatal("Error accepting: ", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.receiveStream(ctx, conn)
+		}()
+	}
+	wg.Wait()
+}
+
+// receiveStream reads the ipfix messages of a session, framed by the length
+// of their header, until the exporter or the context closes it
+func (c *collector) receiveStream(ctx context.Context, conn net.Conn) {
+	source := conn.RemoteAddr().String()
+	log.Infof("session from %s opened", source)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				log.Warnf("Error receiving from %s: %v", source, err)
+			}
+			break
+		}
+		version, length := binary.BigEndian.Uint16(header), binary.BigEndian.Uint16(header[2:])
+		if version != ipfix.VERSION || length < 16 {
+			// the stream can't be framed anymore
+			log.Warnf("invalid ipfix message header from %s, closing the session", source)
+			c.ipfix.failed()
+			break
+		}
+		msg := make([]byte, length)
+		copy(msg, header)
+		if _, err := io.ReadFull(reader, msg[4:]); err != nil {
+			if ctx.Err() == nil {
+				log.Warnf("Error receiving from %s: %v", source, err)
+			}
+			break
+		}
+		c.mutex.Lock()
+		c.receive(source, msg)
+		c.mutex.Unlock()
+	}
+
+	// templates and sequence numbers are scoped by session
+	c.mutex.Lock()
+	delete(c.sessions, source)
+	c.mutex.Unlock()
+	log.Infof("session from %s closed", source)
+}
+
+// receive decodes an export and accounts its records
+func (c *collector) receive(source string, payload []byte) {
+	if len(payload) < 2 {
+		atomic.AddUint64(&c.ignored, 1)
+		return
+	}
+
+	version := binary.BigEndian.Uint16(payload)
+	if version != 5 && version != ipfix.VERSION {
+		atomic.AddUint64(&c.ignored, 1)
+		return
+	}
+	session, found := c.sessions[source]
+	if !found {
+		session = &collectSession{
+			decoder:     ipfix.NewDecoder(),
+			nextSeqNums: map[uint32]uint32{},
+			nextFlowSeq: map[uint32]uint32{},
+		}
+		c.sessions[source] = session
+	}
+
+	switch version {
+	case 5:
+		data, err := legacy.ParseNFlowPayload(payload)
+		if err != nil {
+			c.v5.failed()
+			log.Warnf("invalid netflow v5 export from %s: %v", source, err)
+			return
+		}
+		c.v5.add(len(data.Records), len(payload))
+		engine := uint32(data.Header.EngineType)<<8 | uint32(data.Header.EngineId)
+		c.checkSequence(session.nextFlowSeq, engine, data.Header.FlowSequence, len(data.Records))
+		if c.out != nil {
+			for _, record := range data.Records {
+				c.print(source, 5, engine, v5Fields(data.Header, record))
+			}
+		}
+
+	case ipfix.VERSION:
+		missing := session.decoder.Missing
+		msg, err := session.decoder.Decode(payload)
+		if err != nil {
+			c.ipfix.failed()
+			log.Warnf("invalid ipfix export from %s: %v", source, err)
+			return
+		}
+		atomic.AddUint64(&c.missing, session.decoder.Missing-missing)
+
+		records, _ := msg.FlowStats()
+		c.ipfix.add(records, len(payload))
+		c.checkSequence(session.nextSeqNums, msg.Header.DomainID, msg.Header.SequenceNo, records)
+		if c.out != nil {
+			for _, dataSet := range msg.DataSet {
+				for _, record := range dataSet.Records {
+					c.print(source, ipfix.VERSION, msg.Header.DomainID, ipfixFields(record))
+				}
+			}
+		}
+	}
+}
+
+// checkSequence accounts the records lost since the previous message of an
+// ipfix observation domain or netflow v5 engine, both sequence numbers count
+// the records sent
+func (c *collector) checkSequence(nextSeqNums map[uint32]uint32, domain uint32, seqNo uint32, records int) {
+	expected, found := nextSeqNums[domain]
+	if found && seqNo != expected {
+		gap := seqNo - expected
+		if gap < 1<<31 {
+			atomic.AddUint64(&c.lost, uint64(gap))
+		} else {
+			log.Debugf("message of domain %d is out of order", domain)
+		}
+	}
+	nextSeqNums[domain] = seqNo + uint32(records)
+}
+
+// print a record as a json line, fields are named after the ipfix
+// information elements
+func (c *collector) print(source string, version uint16, domain uint32, fields map[string]interface{}) {
+	fields["exporter"] = source
+	fields["version"] = version
+	fields["domain"] = domain
+	if err := c.out.Encode(fields); err != nil {
+		log.Fatal("Error printing record: ", err)
+	}
+}
+
+// v5Fields names the fields of a netflow v5 record like ipfix elements, the
+// sampling of the header is added to each record
+func v5Fields(header legacy.NetflowHeader, record legacy.NetflowPayload) map[string]interface{} {
+	return map[string]interface{}{
+		"samplingAlgorithm":           header.SampleInterval >> 14,
+		"samplingInterval":            header.SampleInterval & legacy.MAX_SAMPLING_INTERVAL,
+		"sourceIPv4Address":           uint32ToIP(record.SrcIP).String(),
+		"destinationIPv4Address":      uint32ToIP(record.DstIP).String(),
+		"ipNextHopIPv4Address":        uint32ToIP(record.NextHopIP).String(),
+		"ingressInterface":            record.SnmpInIndex,
+		"egressInterface":             record.SnmpOutIndex,
+		"packetDeltaCount":            record.NumPackets,
+		"octetDeltaCount":             record.NumOctets,
+		"flowStartSysUpTime":          record.SysUptimeStart,
+		"flowEndSysUpTime":            record.SysUptimeEnd,
+		"sourceTransportPort":         record.SrcPort,
+		"destinationTransportPort":    record.DstPort,
+		"tcpControlBits":              record.TcpFlags,
+		"protocolIdentifier":          record.IpProtocol,
+		"ipClassOfService":            record.IpTos,
+		"bgpSourceAsNumber":           record.SrcAsNumber,
+		"bgpDestinationAsNumber":      record.DstAsNumber,
+		"sourceIPv4PrefixLength":      record.SrcPrefixMask,
+		"destinationIPv4PrefixLength": record.DstPrefixMask,
+	}
+}
+
+// ipfixFields names the fields of a decoded ipfix record, elements missing
+// from the information model are named after their enterprise number and id
+func ipfixFields(record []ipfix.DataField) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, field := range record {
+		key := ipfix.ElementKey{EnterpriseNo: field.EnterpriseNo, ElementID: field.FieldID &^ ipfix.ENTERPRISE_BIT}
+		name := fmt.Sprintf("element%d", key.ElementID)
+		if key.EnterpriseNo != 0 {
+			name = fmt.Sprintf("enterprise%d.element%d", key.EnterpriseNo, key.ElementID)
+		}
+		if element, found := ipfix.InfoModel[key]; found {
+			name = element.Name
+		}
+		switch v := field.Value.(type) {
+		case net.IP:
+			fields[name] = v.String()
+		case net.HardwareAddr:
+			fields[name] = v.String()
+		case string: //fixed length strings are padded with zeros
+			fields[name] = strings.TrimRight(v, "\x00")
+		case []byte:
+			fields[name] = hex.EncodeToString(v)
+		default:
+			fields[name] = v
+		}
+	}
+	return fields
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// report logs the received counters every interval until the context is done
+func (c *collector) report(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastV5, lastIPFIX counters
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v5, ipfix := c.v5.snapshot(), c.ipfix.snapshot()
+			log.Infof("received in the last %s: v5 %s, ipfix %s", interval, v5.minus(lastV5).receivedString(), ipfix.minus(lastIPFIX).receivedString())
+			lastV5, lastIPFIX = v5, ipfix
+		}
+	}
+}
+
+func (c *collector) logSummary() {
+	log.Infof("netflow v5 received: %s", c.v5.snapshot().receivedString())
+	log.Infof("ipfix received: %s", c.ipfix.snapshot().receivedString())
+	log.Infof("ipfix data sets without template: %d, v5 and ipfix records lost according to sequence numbers: %d",
+		atomic.LoadUint64(&c.missing), atomic.LoadUint64(&c.lost))
+	if ignored := atomic.LoadUint64(&c.ignored); ignored > 0 {
+		log.Infof("datagrams of other versions ignored: %d", ignored)
+	}
+}
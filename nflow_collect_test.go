@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math/rand"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCollectorReceive(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := newCollector()
+
+	v5 := legacy.NewExporter(1, 0, legacy.SAMPLING_NONE, 0)
+	c.receive("10.0.0.1:2055", v5.Encode(legacy.GenerateNetflow(r, 16, nil, false)))
+	if got := c.v5.snapshot(); got.packets != 1 || got.records != 16 {
+		t.Errorf("received netflow v5 %s, want 1 packet of 16 records", got)
+	}
+
+	// the second message of the session is decoded with the template of the first
+	e := ipfix.NewExporter(time.Hour, 0, ipfix.DefaultMTU)
+	first, second := e.GenerateNetflow(r, 0, nil), e.GenerateNetflow(r, 0, nil)
+	records := len(first.DataSet[0].Records) + len(second.DataSet[0].Records)
+	c.receive("10.0.0.1:4739", e.Encode(*first))
+	c.receive("10.0.0.1:4739", e.Encode(*second))
+	if got := c.ipfix.snapshot(); got.packets != 2 || got.records != uint64(records) {
+		t.Errorf("received ipfix %s, want 2 packets of %d records", got, records)
+	}
+
+	// another source is another session, without the template
+	c.receive("10.0.0.2:4739", e.Encode(*e.GenerateNetflow(r, 0, nil)))
+	if missing := atomic.LoadUint64(&c.missing); missing != 1 {
+		t.Errorf("counted %d data sets without template, want 1", missing)
+	}
+
+	c.receive("10.0.0.1:4739", []byte{0, 10, 0, 1})
+	if errors := c.ipfix.snapshot().errors; errors != 1 {
+		t.Errorf("counted %d invalid ipfix exports, want 1", errors)
+	}
+	c.receive("10.0.0.1:6343", []byte{0, 0, 0, 5})
+	if ignored := atomic.LoadUint64(&c.ignored); ignored != 1 {
+		t.Errorf("ignored %d datagrams, want 1", ignored)
+	}
+	if lost := atomic.LoadUint64(&c.lost); lost != 0 {
+		t.Errorf("counted %d lost records, want 0", lost)
+	}
+}
+
+func TestCollectorCheckSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected uint32 // next sequence number of the domain, 0 for its first message
+		seqNo    uint32
+		lost     uint64
+	}{
+		{"first message", 0, 1000, 0},
+		{"in sequence", 1000, 1000, 0},
+		{"gap", 1000, 1010, 10},
+		{"gap across the wrap", 0xfffffff0, 5, 21},
+		{"out of order", 1000, 990, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCollector()
+			nextSeqNums := map[uint32]uint32{}
+			if tt.expected != 0 {
+				nextSeqNums[1] = tt.expected
+			}
+			c.checkSequence(nextSeqNums, 1, tt.seqNo, 16)
+			if lost := atomic.LoadUint64(&c.lost); lost != tt.lost {
+				t.Errorf("counted %d lost records, want %d", lost, tt.lost)
+			}
+			if next := nextSeqNums[1]; next != tt.seqNo+16 {
+				t.Errorf("next sequence number %d, want %d", next, tt.seqNo+16)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"nflow-generator/pb"
+	"nflow-generator/sflow"
+	"nflow-generator/v9"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
+)
+
+// distribution modes of the packets across the collectors
+const (
+	roundRobin = "round-robin" // each packet goes to the next collector
+	replicate  = "replicate"   // each packet goes to every collector
+	weighted   = "weighted"    // each packet goes to a collector picked by weight
+	hashed     = "hash"        // each flow goes to a collector picked by its 5-tuple
+)
+
+// distribution picks the collectors of the generated packets, it is shared
+// by all the workers
+type distribution struct {
+	mode    string
+	targets int
+	weights []int // cumulative weights of the collectors
+	next    uint64
+}
+
+func newDistribution(mode string, targets int, weights string) (*distribution, error) {
+	d := &distribution{
+		mode:    mode,
+		targets: targets,
+	}
+	switch mode {
+	case roundRobin, replicate, hashed:
+	case weighted:
+		var values []string
+		if weights != "" {
+			values = strings.Split(weights, ",")
+			if len(values) != targets {
+				return nil, fmt.Errorf("%d weights for %d targets", len(values), targets)
+			}
+		}
+		total := 0
+		for i := 0; i < targets; i++ {
+			weight := 1
+			if values != nil {
+				var err error
+				weight, err = strconv.Atoi(strings.TrimSpace(values[i]))
+				if err != nil || weight < 0 {
+					return nil, fmt.Errorf("invalid weight %s", values[i])
+				}
+			}
+			total += weight
+			d.weights = append(d.weights, total)
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("at least one weight must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("distribution %s is not valid, use '%s', '%s', '%s' or '%s'",
+			mode, roundRobin, replicate, weighted, hashed)
+	}
+	return d, nil
+}
+
+// pick returns the collectors of the next packet, none in hash mode as
+// the flows of each packet are split across the collectors
+func (d *distribution) pick(r *rand.Rand) []int {
+	switch d.mode {
+	case roundRobin:
+		return []int{int((atomic.AddUint64(&d.next, 1) - 1) % uint64(d.targets))}
+	case replicate:
+		all := make([]int, d.targets)
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	case weighted:
+		n := r.Intn(d.weights[len(d.weights)-1])
+		for i, weight := range d.weights {
+			if n < weight {
+				return []int{i}
+			}
+		}
+	}
+	return nil
+}
+
+// perTarget tells if the workers keep exporter state per collector, so that
+// each collector receives its own ipfix templates, sflow counters and sequence numbers
+func (d *distribution) perTarget() bool {
+	return d.mode == roundRobin || d.mode == weighted
+}
+
+// bucket returns the collector of a flow key
+func (d *distribution) bucket(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(d.targets))
+}
+
+// packet is an encoded message ready to be sent to a collector
+type packet struct {
+	target       int
+	byteArray    []byte
+	flows        []*pbflow.Record
+	flowCount    int
+	octets       uint64
+	fingerprints []fingerprint // flows of the packet, only kept with --verify
+}
+
+// copies of a packet for each of the picked collectors
+func copies(targets []int, byteArray []byte, flows []*pbflow.Record, flowCount int, octets uint64, fingerprints []fingerprint) []packet {
+	var packets []packet
+	for _, target := range targets {
+		packets = append(packets, packet{target, byteArray, flows, flowCount, octets, fingerprints})
+	}
+	return packets
+}
+
+// legacyPackets encodes the packets with the exporters of their collectors,
+// so that each collector receives a gapless flow sequence
+func (d *distribution) legacyPackets(exporters []*legacy.Exporter, data legacy.Netflow, targets []int) []packet {
+	if d.mode != hashed {
+		e := exporters[0]
+		if d.perTarget() {
+			e = exporters[targets[0]]
+		}
+		flowCount, octets := data.FlowStats()
+		return copies(targets, e.Encode(data), nil, flowCount, octets, legacyFingerprints(data))
+	}
+	var packets []packet
+	parts := data.Split(d.targets, func(record legacy.NetflowPayload) int {
+		return d.bucket(record.FlowKey())
+	})
+	for target, part := range parts {
+		if flowCount, octets := part.FlowStats(); flowCount > 0 {
+			packets = append(packets, packet{target, exporters[target].Encode(part), nil, flowCount, octets, legacyFingerprints(part)})
+		}
+	}
+	return packets
+}
+
+func (d *distribution) v9Packets(exporters []*v9.Exporter, msg v9.Message, targets []int) []packet {
+	if d.mode != hashed {
+		e := exporters[0]
+		if d.perTarget() {
+			e = exporters[targets[0]]
+		}
+		flowCount, octets := msg.FlowStats()
+		return copies(targets, e.Encode(msg), nil, flowCount, octets, v9Fingerprints(msg))
+	}
+	var packets []packet
+	parts := msg.Split(d.targets, func(record []v9.DataField) int {
+		return d.bucket(v9.FlowKey(record))
+	})
+	for target, part := range parts {
+		if flowCount, octets := part.FlowStats(); flowCount > 0 {
+			packets = append(packets, packet{target, exporters[target].Encode(part), nil, flowCount, octets, v9Fingerprints(part)})
+		}
+	}
+	return packets
+}
+
+// ipfixExporter returns the exporter generating the messages of a packet
+func (d *distribution) ipfixExporter(exporters []*ipfix.Exporter, targets []int) *ipfix.Exporter {
+	if d.perTarget() {
+		return exporters[targets[0]]
+	}
+	return exporters[0]
+}
+
+// ipfixPackets encodes the packets with the exporters of their collectors,
+// a message generated by the first exporter is split in hash mode so that
+// each collector gets its own templates and gapless sequence numbers
+func (d *distribution) ipfixPackets(exporters []*ipfix.Exporter, msg ipfix.Message, targets []int) []packet {
+	e := d.ipfixExporter(exporters, targets)
+	if d.mode != hashed {
+		flowCount, octets := msg.FlowStats()
+		return copies(targets, e.Encode(msg), nil, flowCount, octets, ipfixFingerprints(msg))
+	}
+	var packets []packet
+	parts := msg.Split(d.targets, func(record []ipfix.DataField) int {
+		return d.bucket(ipfix.FlowKey(record))
+	})
+	for target, part := range parts {
+		part = exporters[target].Adopt(e, part)
+		// parts holding templates are sent even without records so that
+		// every collector can decode the next ones
+		if flowCount, octets := part.FlowStats(); flowCount > 0 || len(part.TemplateSet) > 0 {
+			packets = append(packets, packet{target, exporters[target].Encode(part), nil, flowCount, octets, ipfixFingerprints(part)})
+		}
+	}
+	return packets
+}
+
+// sflowExporter returns the exporter generating the datagrams of a packet
+func (d *distribution) sflowExporter(exporters []*sflow.Exporter, targets []int) *sflow.Exporter {
+	if d.perTarget() {
+		return exporters[targets[0]]
+	}
+	return exporters[0]
+}
+
+func (d *distribution) sflowPackets(exporters []*sflow.Exporter, datagram sflow.Datagram, targets []int) []packet {
+	if d.mode != hashed {
+		flowCount, octets := datagram.FlowStats()
+		return copies(targets, d.sflowExporter(exporters, targets).Encode(datagram), nil, flowCount, octets, nil)
+	}
+	var packets []packet
+	parts := datagram.Split(d.targets, func(sample sflow.FlowSample) int {
+		return d.bucket(sflow.FlowKey(sample))
+	})
+	for target, part := range parts {
+		// counter samples are sent even without flow samples
+		if flowCount, octets := part.FlowStats(); flowCount > 0 || len(part.CounterSamples) > 0 {
+			part = exporters[target].Adopt(part)
+			packets = append(packets, packet{target, exporters[target].Encode(part), nil, flowCount, octets, nil})
+		}
+	}
+	return packets
+}
+
+func (d *distribution) pbPackets(flows []*pbflow.Record, targets []int) []packet {
+	octets := uint64(0)
+	for _, flow := range flows {
+		octets += flow.Bytes
+	}
+	if d.mode != hashed {
+		return copies(targets, nil, flows, len(flows), octets, pbFingerprints(flows))
+	}
+	parts := make([][]*pbflow.Record, d.targets)
+	for _, flow := range flows {
+		i := d.bucket(pb.FlowKey(flow))
+		parts[i] = append(parts[i], flow)
+	}
+	var packets []packet
+	for target, part := range parts {
+		if len(part) > 0 {
+			octets := uint64(0)
+			for _, flow := range part {
+				octets += flow.Bytes
+			}
+			packets = append(packets, packet{target, nil, part, len(part), octets, pbFingerprints(part)})
+		}
+	}
+	return packets
+}
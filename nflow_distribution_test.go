@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math/rand"
+	"nflow-generator/ipfix"
+	"testing"
+	"time"
+)
+
+func TestNewDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		weights string
+		want    []int
+		err     bool
+	}{
+		{"round robin", roundRobin, "", nil, false},
+		{"default weights", weighted, "", []int{1, 2, 3}, false},
+		{"weights", weighted, "2, 1,1", []int{2, 3, 4}, false},
+		{"zero weight", weighted, "0,1,1", []int{0, 1, 2}, false},
+		{"negative weight", weighted, "-1,1,1", nil, true},
+		{"all zero", weighted, "0,0,0", nil, true},
+		{"weights count", weighted, "1,1", nil, true},
+		{"not a number", weighted, "1,a,1", nil, true},
+		{"unknown mode", "random", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDistribution(tt.mode, 3, tt.weights)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if len(d.weights) != len(tt.want) {
+				t.Fatalf("cumulative weights %v, want %v", d.weights, tt.want)
+			}
+			for i := range tt.want {
+				if d.weights[i] != tt.want[i] {
+					t.Errorf("cumulative weights %v, want %v", d.weights, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDistributionPick(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	d, _ := newDistribution(roundRobin, 3, "")
+	for i := 0; i < 6; i++ {
+		if got := d.pick(r); len(got) != 1 || got[0] != i%3 {
+			t.Errorf("round robin pick %d is %v, want [%d]", i, got, i%3)
+		}
+	}
+
+	d, _ = newDistribution(replicate, 3, "")
+	if got := d.pick(r); len(got) != 3 {
+		t.Errorf("replicate picked %v, want every collector", got)
+	}
+
+	d, _ = newDistribution(hashed, 3, "")
+	if got := d.pick(r); got != nil {
+		t.Errorf("hash picked %v, want none", got)
+	}
+
+	d, _ = newDistribution(weighted, 3, "0,3,1")
+	counts := make([]int, 3)
+	for i := 0; i < 4000; i++ {
+		counts[d.pick(r)[0]]++
+	}
+	if counts[0] != 0 || counts[1] < 2*counts[2] {
+		t.Errorf("weighted picks %v, want none for the first collector and about 3 for 1 on the others", counts)
+	}
+}
+
+func TestDistributionBucket(t *testing.T) {
+	d, _ := newDistribution(hashed, 4, "")
+	key := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x04, 0x00, 6}
+	first := d.bucket(key)
+	for i := 0; i < 10; i++ {
+		if got := d.bucket(append([]byte{}, key...)); got != first {
+			t.Fatalf("flow key bucketed to %d then %d", first, got)
+		}
+	}
+	buckets := map[int]bool{}
+	for i := 0; i < 256; i++ {
+		key[3] = byte(i)
+		b := d.bucket(key)
+		if b < 0 || b >= 4 {
+			t.Fatalf("bucket %d out of the 4 collectors", b)
+		}
+		buckets[b] = true
+	}
+	if len(buckets) != 4 {
+		t.Errorf("flows spread on %d collectors, want 4", len(buckets))
+	}
+}
+
+func TestDistributionIPFIXHash(t *testing.T) {
+	d, _ := newDistribution(hashed, 3, "")
+	exporters := []*ipfix.Exporter{
+		ipfix.NewExporter(time.Hour, 0, ipfix.DefaultMTU),
+		ipfix.NewExporter(time.Hour, 0, ipfix.DefaultMTU),
+		ipfix.NewExporter(time.Hour, 0, ipfix.DefaultMTU),
+	}
+	decoders := []*ipfix.Decoder{ipfix.NewDecoder(), ipfix.NewDecoder(), ipfix.NewDecoder()}
+	nextSeqNums := make([]uint32, 3)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5; i++ {
+		msg := exporters[0].GenerateNetflow(r, 0, nil)
+		sent := 0
+		for _, p := range d.ipfixPackets(exporters, *msg, nil) {
+			// every collector decodes its part with the templates it received
+			decoded, err := decoders[p.target].Decode(p.byteArray)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Header.SequenceNo != nextSeqNums[p.target] {
+				t.Errorf("collector %d got sequence number %d, want %d", p.target, decoded.Header.SequenceNo, nextSeqNums[p.target])
+			}
+			records := 0
+			for _, set := range decoded.DataSet {
+				records += len(set.Records)
+				for _, record := range set.Records {
+					if target := d.bucket(ipfix.FlowKey(record)); target != p.target {
+						t.Errorf("flow of collector %d sent to %d", target, p.target)
+					}
+				}
+			}
+			if records != p.flowCount {
+				t.Errorf("collector %d decoded %d records, want %d", p.target, records, p.flowCount)
+			}
+			nextSeqNums[p.target] += uint32(records)
+			sent += records
+		}
+		if want := len(msg.DataSet[0].Records); sent != want {
+			t.Errorf("message %d split in %d records, want %d", i, sent, want)
+		}
+	}
+	for i, decoder := range decoders {
+		if decoder.Missing != 0 {
+			t.Errorf("collector %d missed %d templates", i, decoder.Missing)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"nflow-generator/sflow"
+	"nflow-generator/v9"
+	"strings"
+)
+
+// exporter is a simulated router: it sends from its own sockets, bound to
+// one of --source-ips when set, with its own engine id, observation domain
+// and sequence numbers towards each collector
+type exporter struct {
+	id       int
+	domainID uint32 // ipfix observation domain and netflow v9 source id
+	senders  []*sender
+	legacy   []*legacy.Exporter
+	v9       []*v9.Exporter
+	ipfix    []*ipfix.Exporter
+	sflow    []*sflow.Exporter
+}
+
+func newExporter(id int) *exporter {
+	local := sourceIP(id)
+	e := &exporter{
+		id:       id,
+		domainID: uint32(opts.DomainID + id),
+		senders:  connectTargets(id, local),
+		legacy:   make([]*legacy.Exporter, len(targets)),
+		v9:       make([]*v9.Exporter, len(targets)),
+		ipfix:    make([]*ipfix.Exporter, len(targets)),
+		sflow:    make([]*sflow.Exporter, len(targets)),
+	}
+	// the state of a collector is kept per exporter and per collector
+	for i := range targets {
+		e.legacy[i] = legacy.NewExporter(uint8(opts.EngineType), uint8(opts.EngineID+id), v5SamplingMode, v5SamplingInterval)
+		e.v9[i] = v9.NewExporter(e.domainID, v5SamplingMode, v5SamplingInterval)
+		if opts.Type == "ipfix" {
+			e.ipfix[i] = ipfix.NewExporter(opts.TemplateInterval, opts.TemplatePackets, opts.MTU)
+			e.ipfix[i].Scenario = flowScenario
+			e.ipfix[i].Fields = ipfixTemplate
+		}
+		if opts.Type == "sflow" {
+			e.sflow[i] = sflow.NewExporter(uint32(opts.SamplingRate), sflow.DefaultCounterInterval, opts.MTU)
+			e.sflow[i].Scenario = flowScenario
+			e.sflow[i].SubAgentID = uint32(id)
+			if local != nil {
+				e.sflow[i].AgentAddress = local
+			}
+		}
+	}
+	// a new tcp or tls session starts with the templates already announced
+	if opts.Type == "ipfix" {
+		for i, s := range e.senders {
+			ipfixExporter := e.ipfix[0]
+			if packetDistribution.mode != replicate {
+				ipfixExporter = e.ipfix[i]
+			}
+			s.onConnect = func() []byte {
+				return ipfixExporter.TemplateMessage(e.domainID)
+			}
+		}
+	}
+	return e
+}
+
+func (e *exporter) close() {
+	for _, s := range e.senders {
+		s.close()
+	}
+}
+
+// workerExporters returns the exporters simulated by a worker, the
+// exporters are spread across the workers in turn
+func workerExporters(worker int) []*exporter {
+	var exporters []*exporter
+	for id := worker; id < opts.Exporters; id += opts.Concurrency {
+		exporters = append(exporters, newExporter(id))
+	}
+	return exporters
+}
+
+// parseSourceIPs reads the --source-ips list
+func parseSourceIPs(s string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, value := range strings.Split(s, ",") {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			return nil, fmt.Errorf("source ip %s is not valid", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// sourceIP returns the local address of an exporter, nil to let the
+// system pick it
+func sourceIP(id int) net.IP {
+	if len(sourceIPs) == 0 {
+		return nil
+	}
+	return sourceIPs[id%len(sourceIPs)]
+}
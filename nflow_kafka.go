@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"nflow-generator/pb"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+)
+
+// brokers of the in-process kafka stand-in
+const kafkaMemoryBrokers = "memory"
+
+// partitions of the topic of the in-process kafka stand-in
+const kafkaMemoryPartitions = 4
+
+// messages kept per partition by the in-process kafka stand-in, the older
+// ones are dropped so that long runs don't grow without limit
+const kafkaMemoryRetention = 100000
+
+// kafkaWriter is implemented by kafka-go writers and the in-process stand-in
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaPublisher publishes pb records to a kafka topic, one message per record
+type kafkaPublisher struct {
+	name     string // kafka://brokers/topic
+	topic    string
+	encoding string // protobuf or json
+	key      string // partition key of the messages
+	writer   kafkaWriter
+	memory   *memoryBroker // set for the in-process stand-in
+}
+
+// newKafkaPublisher parses a kafka://broker1:9092,broker2:9092/topic url, the
+// brokers are replaced by an in-process stand-in with kafka://memory/topic
+func newKafkaPublisher(url, encoding, key string) (*kafkaPublisher, error) {
+	brokers, topic := "", ""
+	if i := strings.LastIndex(url, "/"); i > len("kafka://") {
+		brokers, topic = url[len("kafka://"):i], url[i+1:]
+	}
+	if brokers == "" || topic == "" {
+		return nil, fmt.Errorf("output %s is not valid, use 'kafka://broker:9092/topic'", url)
+	}
+
+	switch encoding {
+	case "protobuf", "json":
+	default:
+		return nil, fmt.Errorf("kafka encoding %s is not valid, use 'protobuf' or 'json'", encoding)
+	}
+
+	var balancer kafka.Balancer
+	switch key {
+	case "none":
+		balancer = &kafka.RoundRobin{}
+	case "flow", "src", "dst", "interface":
+		balancer = &kafka.Hash{}
+	default:
+		return nil, fmt.Errorf("kafka key %s is not valid, use 'none', 'flow', 'src', 'dst' or 'interface'", key)
+	}
+
+	o := &kafkaPublisher{
+		name:     url,
+		topic:    topic,
+		encoding: encoding,
+		key:      key,
+	}
+	if brokers == kafkaMemoryBrokers {
+		o.memory = newMemoryBroker(balancer, kafkaMemoryPartitions, kafkaMemoryRetention)
+		o.writer = o.memory
+		return o, nil
+	}
+	for _, broker := range strings.Split(brokers, ",") {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return nil, fmt.Errorf("kafka broker %s is not valid: %v", broker, err)
+		}
+	}
+	o.writer = &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     balancer,
+		BatchTimeout: time.Millisecond,
+		RequiredAcks: kafka.RequireOne,
+	}
+	return o, nil
+}
+
+// publish the records and return the bytes of the messages
+func (o *kafkaPublisher) publish(ctx context.Context, flows []*pbflow.Record) (int, error) {
+	msgs := make([]kafka.Message, 0, len(flows))
+	size := 0
+	for _, flow := range flows {
+		var value []byte
+		var err error
+		if o.encoding == "json" {
+			value, err = json.Marshal(pb.RecordMap(flow))
+		} else {
+			value, err = proto.Marshal(flow)
+		}
+		if err != nil {
+			return size, err
+		}
+		msg := kafka.Message{
+			Key:   o.messageKey(flow),
+			Value: value,
+		}
+		size += len(msg.Key) + len(msg.Value)
+		msgs = append(msgs, msg)
+	}
+	return size, o.writer.WriteMessages(ctx, msgs...)
+}
+
+// messageKey returns the partition key of a record
+func (o *kafkaPublisher) messageKey(flow *pbflow.Record) []byte {
+	switch o.key {
+	case "flow":
+		return pb.FlowKey(flow)
+	case "src":
+		return []byte(pb.IPString(flow.GetNetwork().GetSrcAddr()))
+	case "dst":
+		return []byte(pb.IPString(flow.GetNetwork().GetDstAddr()))
+	case "interface":
+		return []byte(flow.Interface)
+	}
+	return nil
+}
+
+// records decodes the messages held by the in-process stand-in
+func (o *kafkaPublisher) records() ([]sinkRecord, error) {
+	if dropped := o.memory.dropped(); dropped > 0 {
+		log.Warnf("kafka stand-in keeps the last %d messages of each partition, %d older ones are not read", kafkaMemoryRetention, dropped)
+	}
+	var records []sinkRecord
+	for _, msg := range o.memory.messages() {
+		value := msg.Value
+		if o.encoding == "protobuf" {
+			var flow pbflow.Record
+			if err := proto.Unmarshal(msg.Value, &flow); err != nil {
+				return nil, err
+			}
+			var err error
+			if value, err = json.Marshal(pb.RecordMap(&flow)); err != nil {
+				return nil, err
+			}
+		}
+		record := sinkRecord{}
+		decoder := json.NewDecoder(strings.NewReader(string(value)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (o *kafkaPublisher) close() error {
+	if o.memory != nil {
+		for partition, count := range o.memory.counts() {
+			log.Infof("kafka stand-in topic %s partition %d: %d messages", o.topic, partition, count)
+		}
+	}
+	return o.writer.Close()
+}
+
+// memoryBroker is an in-process stand-in of a kafka broker, it assigns the
+// messages to the partitions of a topic like kafka-go writers do and keeps
+// the last retention messages of each partition
+type memoryBroker struct {
+	mutex      sync.Mutex
+	balancer   kafka.Balancer
+	retention  int
+	partitions [][]kafka.Message
+	written    []int // messages written per partition, dropped ones included
+}
+
+func newMemoryBroker(balancer kafka.Balancer, partitions int, retention int) *memoryBroker {
+	return &memoryBroker{
+		balancer:   balancer,
+		retention:  retention,
+		partitions: make([][]kafka.Message, partitions),
+		written:    make([]int, partitions),
+	}
+}
+
+func (b *memoryBroker) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ids := make([]int, len(b.partitions))
+	for i := range ids {
+		ids[i] = i
+	}
+	for _, msg := range msgs {
+		partition := b.balancer.Balance(msg, ids...)
+		msg.Partition = partition
+		msg.Offset = int64(b.written[partition])
+		msg.Time = time.Now()
+		b.written[partition]++
+		b.partitions[partition] = append(b.partitions[partition], msg)
+		if n := len(b.partitions[partition]); n > b.retention {
+			b.partitions[partition] = b.partitions[partition][n-b.retention:]
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) Close() error {
+	return nil
+}
+
+// messages of all the partitions
+func (b *memoryBroker) messages() []kafka.Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var msgs []kafka.Message
+	for _, partition := range b.partitions {
+		msgs = append(msgs, partition...)
+	}
+	return msgs
+}
+
+// counts of messages written per partition
+func (b *memoryBroker) counts() []int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]int{}, b.written...)
+}
+
+// count of messages dropped from all the partitions
+func (b *memoryBroker) dropped() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	dropped := 0
+	for i, partition := range b.partitions {
+		dropped += b.written[i] - len(partition)
+	}
+	return dropped
+}
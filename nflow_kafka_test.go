@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"nflow-generator/pb"
+	"sort"
+	"testing"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+)
+
+var testKafkaIPs = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}
+
+// publishTestRecords publishes generated records to the in-process stand-in
+func publishTestRecords(t *testing.T, encoding, key string, count int) (*kafkaPublisher, []*pbflow.Record) {
+	t.Helper()
+	o, err := newKafkaPublisher("kafka://memory/flows", encoding, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows := pb.GenerateRecords(rand.New(rand.NewSource(1)), testKafkaIPs, count)
+	size, err := o.publish(context.Background(), flows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 {
+		t.Fatal("published 0 bytes")
+	}
+	return o, flows
+}
+
+// sorted json documents of records, to compare them whatever their partition
+func sortedJSON(t *testing.T, records []sinkRecord) []string {
+	t.Helper()
+	var docs []string
+	for _, record := range records {
+		doc, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, string(doc))
+	}
+	sort.Strings(docs)
+	return docs
+}
+
+func TestKafkaPublish(t *testing.T) {
+	o, flows := publishTestRecords(t, "protobuf", "none", 16)
+	if msgs := o.memory.messages(); len(msgs) != len(flows) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(flows))
+	}
+	total := 0
+	for _, count := range o.memory.counts() {
+		total += count
+	}
+	if total != len(flows) {
+		t.Errorf("partition counts sum to %d, want %d", total, len(flows))
+	}
+	if err := o.close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKafkaProtobufRoundTrip(t *testing.T) {
+	o, flows := publishTestRecords(t, "protobuf", "flow", 32)
+	for _, msg := range o.memory.messages() {
+		var flow pbflow.Record
+		if err := proto.Unmarshal(msg.Value, &flow); err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, sent := range flows {
+			found = found || proto.Equal(&flow, sent)
+		}
+		if !found {
+			t.Errorf("message at partition %d offset %d is not a published record", msg.Partition, msg.Offset)
+		}
+	}
+}
+
+func TestKafkaJSONRoundTrip(t *testing.T) {
+	for _, encoding := range []string{"protobuf", "json"} {
+		t.Run(encoding, func(t *testing.T) {
+			o, flows := publishTestRecords(t, encoding, "none", 32)
+			got, err := o.records()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []sinkRecord
+			for _, flow := range flows {
+				doc, err := json.Marshal(pb.RecordMap(flow))
+				if err != nil {
+					t.Fatal(err)
+				}
+				record := sinkRecord{}
+				decoder := json.NewDecoder(bytes.NewReader(doc))
+				decoder.UseNumber()
+				if err := decoder.Decode(&record); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, record)
+			}
+			gotDocs, wantDocs := sortedJSON(t, got), sortedJSON(t, want)
+			if len(gotDocs) != len(wantDocs) {
+				t.Fatalf("got %d records, want %d", len(gotDocs), len(wantDocs))
+			}
+			for i := range wantDocs {
+				if gotDocs[i] != wantDocs[i] {
+					t.Errorf("record %d:\n got %s\nwant %s", i, gotDocs[i], wantDocs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaPartitioning(t *testing.T) {
+	for _, key := range []string{"none", "flow", "src", "dst", "interface"} {
+		t.Run(key, func(t *testing.T) {
+			o, flows := publishTestRecords(t, "protobuf", key, 64)
+			msgs := o.memory.messages()
+			if key == "none" {
+				// round-robin spreads the messages evenly
+				for partition, count := range o.memory.counts() {
+					if count != len(flows)/kafkaMemoryPartitions {
+						t.Errorf("partition %d holds %d messages, want %d", partition, count, len(flows)/kafkaMemoryPartitions)
+					}
+				}
+				for _, msg := range msgs {
+					if msg.Key != nil {
+						t.Errorf("message key %q, want none", msg.Key)
+					}
+				}
+				return
+			}
+			// a key always goes to the same partition
+			partitions := map[string]int{}
+			for _, msg := range msgs {
+				var flow pbflow.Record
+				if err := proto.Unmarshal(msg.Value, &flow); err != nil {
+					t.Fatal(err)
+				}
+				if want := o.messageKey(&flow); !bytes.Equal(msg.Key, want) {
+					t.Errorf("message key %q, want %q", msg.Key, want)
+				}
+				if partition, found := partitions[string(msg.Key)]; found && partition != msg.Partition {
+					t.Errorf("key %q in partitions %d and %d", msg.Key, partition, msg.Partition)
+				}
+				partitions[string(msg.Key)] = msg.Partition
+			}
+			if len(partitions) < 2 {
+				t.Errorf("%d distinct keys, the test records should have several", len(partitions))
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerRetention(t *testing.T) {
+	b := newMemoryBroker(&kafka.RoundRobin{}, 1, 3)
+	for i := 0; i < 5; i++ {
+		if err := b.WriteMessages(context.Background(), kafka.Message{Value: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs := b.messages()
+	if len(msgs) != 3 {
+		t.Fatalf("kept %d messages, want 3", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Offset != int64(i+2) || msg.Value[0] != byte(i+2) {
+			t.Errorf("message %d has offset %d and value %d, want %d", i, msg.Offset, msg.Value[0], i+2)
+		}
+	}
+	if counts := b.counts(); counts[0] != 5 {
+		t.Errorf("count of written messages %d, want 5", counts[0])
+	}
+	if dropped := b.dropped(); dropped != 2 {
+		t.Errorf("dropped %d messages, want 2", dropped)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics exposes the statistics of the workers to prometheus, labelled by
+// exporter type and collector target
+type metrics struct {
+	exporterType string
+	packets      *prometheus.CounterVec
+	records      *prometheus.CounterVec
+	bytes        *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	registry     *prometheus.Registry
+}
+
+func newMetrics(exporterType string) *metrics {
+	labels := []string{"type", "target"}
+	m := &metrics{
+		exporterType: exporterType,
+		packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nflow_generator",
+			Name:      "packets_sent_total",
+			Help:      "Number of packets sent to the collectors.",
+		}, labels),
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nflow_generator",
+			Name:      "records_sent_total",
+			Help:      "Number of flow records sent to the collectors.",
+		}, labels),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nflow_generator",
+			Name:      "bytes_sent_total",
+			Help:      "Number of bytes sent on the wire to the collectors.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nflow_generator",
+			Name:      "send_errors_total",
+			Help:      "Number of packets that could not be sent to the collectors.",
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nflow_generator",
+			Name:      "send_duration_seconds",
+			Help:      "Time spent sending a packet to the collectors.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, labels),
+		registry: prometheus.NewRegistry(),
+	}
+	m.registry.MustRegister(
+		m.packets, m.records, m.bytes, m.errors, m.latency,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// init creates the series of every target so they are exported with a zero value
+func (m *metrics) init(targets []string) {
+	for _, target := range targets {
+		m.packets.WithLabelValues(m.exporterType, target)
+		m.records.WithLabelValues(m.exporterType, target)
+		m.bytes.WithLabelValues(m.exporterType, target)
+		m.errors.WithLabelValues(m.exporterType, target)
+		m.latency.WithLabelValues(m.exporterType, target)
+	}
+}
+
+func (m *metrics) sent(target string, records, bytes int, latency time.Duration) {
+	m.packets.WithLabelValues(m.exporterType, target).Inc()
+	m.records.WithLabelValues(m.exporterType, target).Add(float64(records))
+	m.bytes.WithLabelValues(m.exporterType, target).Add(float64(bytes))
+	m.latency.WithLabelValues(m.exporterType, target).Observe(latency.Seconds())
+}
+
+func (m *metrics) failed(target string, latency time.Duration) {
+	m.errors.WithLabelValues(m.exporterType, target).Inc()
+	m.latency.WithLabelValues(m.exporterType, target).Observe(latency.Seconds())
+}
+
+// serve exposes the metrics on /metrics in the background
+func (m *metrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatal("Error serving metrics: ", err)
+		}
+	}()
+	log.Infof("serving metrics on %s/metrics", addr)
+}
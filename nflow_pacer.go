@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pacer is a token bucket shared by all the workers, tokens are flows, packets or bits
+type pacer struct {
+	mutex  sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64 // max tokens accumulated while idle
+	tokens float64
+	last   time.Time
+}
+
+func newPacer(rate float64) *pacer {
+	return &pacer{
+		rate:   rate,
+		burst:  rate / 10,
+		tokens: 0,
+		last:   time.Now(),
+	}
+}
+
+// Wait takes n tokens from the bucket and sleeps until they are earned.
+// The bucket can go in debt so that large packets are paced on average.
+// It returns false if the context is done before.
+func (p *pacer) Wait(ctx context.Context, n float64) bool {
+	p.mutex.Lock()
+	now := time.Now()
+	p.tokens += now.Sub(p.last).Seconds() * p.rate
+	if p.tokens > p.burst {
+		p.tokens = p.burst
+	}
+	p.last = now
+	p.tokens -= n
+	var wait time.Duration
+	if p.tokens < 0 {
+		wait = time.Duration(-p.tokens / p.rate * float64(time.Second))
+	}
+	p.mutex.Unlock()
+
+	return sleep(ctx, wait)
+}
+
+// sleep waits for d and returns false if the context is done before
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// budget is the number of packets or flows shared by all the workers for --count
+type budget struct {
+	limit uint64
+	flows bool // count flows instead of packets
+	used  uint64
+}
+
+func newBudget(limit uint64, flows bool) *budget {
+	return &budget{
+		limit: limit,
+		flows: flows,
+	}
+}
+
+// take accounts a packet of n flows and returns false once the budget is spent.
+// A packet is sent as long as some flows remain so the last one can exceed the budget.
+func (b *budget) take(n int) bool {
+	if !b.flows {
+		return atomic.AddUint64(&b.used, 1) <= b.limit
+	}
+	used := atomic.AddUint64(&b.used, uint64(n))
+	return used-uint64(n) < b.limit
+}
+
+var rateRegexp = regexp.MustCompile(`^([0-9.]+)\s*([kKMG]?)([a-zA-Z/]*)$`)
+
+var rateMultipliers = map[string]float64{
+	"":  1,
+	"k": 1e3,
+	"K": 1e3,
+	"M": 1e6,
+	"G": 1e9,
+}
+
+// parseRate reads values like 50000fps, 10kpps or 200Mbps and returns
+// the rate per second and its unit among the allowed ones
+func parseRate(s string, defaultUnit string, units ...string) (float64, string, error) {
+	m := rateRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, "", fmt.Errorf("invalid rate %s", s)
+	}
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || value <= 0 {
+		return 0, "", fmt.Errorf("invalid rate %s", s)
+	}
+	unit := strings.TrimSuffix(m[3], "/s")
+	if unit == "" {
+		unit = defaultUnit
+	}
+	for _, u := range units {
+		if unit == u {
+			return value * rateMultipliers[m[2]], unit, nil
+		}
+	}
+	return 0, "", fmt.Errorf("invalid rate unit %s in %s, use one of %s", m[3], s, strings.Join(units, ", "))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate  string
+		units []string
+		want  float64
+		unit  string
+		err   bool
+	}{
+		{"50000", []string{"fps", "pps"}, 50000, "fps", false},
+		{"50000fps", []string{"fps", "pps"}, 50000, "fps", false},
+		{"10kpps", []string{"fps", "pps"}, 10000, "pps", false},
+		{"10Kpps", []string{"fps", "pps"}, 10000, "pps", false},
+		{"1.5 Mpps", []string{"fps", "pps"}, 1.5e6, "pps", false},
+		{"200Mbps", []string{"bps"}, 200e6, "bps", false},
+		{"1Gbps", []string{"bps"}, 1e9, "bps", false},
+		{"100pps/s", []string{"fps", "pps"}, 100, "pps", false},
+		{"10kflows", []string{"packets", "flows"}, 10000, "flows", false},
+		{"10kbps", []string{"fps", "pps"}, 0, "", true},
+		{"0fps", []string{"fps"}, 0, "", true},
+		{"-1fps", []string{"fps"}, 0, "", true},
+		{"10Tbps", []string{"bps"}, 0, "", true},
+		{"fast", []string{"fps"}, 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rate, func(t *testing.T) {
+			got, unit, err := parseRate(tt.rate, tt.units[0], tt.units...)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error %v", err, tt.err)
+			}
+			if got != tt.want || unit != tt.unit {
+				t.Errorf("parsed %v %s, want %v %s", got, unit, tt.want, tt.unit)
+			}
+		})
+	}
+}
+
+func TestPacerWait(t *testing.T) {
+	p := newPacer(1000)
+	start := time.Now()
+	// the bucket starts empty, 50 tokens are earned in 50ms
+	if !p.Wait(context.Background(), 50) {
+		t.Fatal("wait interrupted")
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("waited %s for 50 tokens at 1000/s, want 50ms", elapsed)
+	}
+
+	// the tokens earned while idle are capped by the burst
+	time.Sleep(300 * time.Millisecond)
+	p.Wait(context.Background(), 0)
+	if p.tokens > p.burst {
+		t.Errorf("accumulated %v tokens, want at most the burst %v", p.tokens, p.burst)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if p.Wait(ctx, 1000) {
+		t.Error("wait not interrupted by a canceled context")
+	}
+}
+
+func TestBudgetTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		flows bool
+		takes []int
+		want  []bool
+	}{
+		{"packets", false, []int{16, 16, 16}, []bool{true, true, false}},
+		{"flows", true, []int{16, 16, 16}, []bool{true, true, false}},
+		{"last packet exceeds the flows", true, []int{16, 30, 1}, []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := uint64(2)
+			if tt.flows {
+				limit = 32
+			}
+			b := newBudget(limit, tt.flows)
+			for i, n := range tt.takes {
+				if got := b.take(n); got != tt.want[i] {
+					t.Errorf("take %d of %d flows returned %v, want %v", i, n, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
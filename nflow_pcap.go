@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// addresses of the simulated exporters in pcap files
+var (
+	pcapSourceIPv4 = net.ParseIP("10.10.29.1").To4()
+	pcapSourceIPv6 = net.ParseIP("2001:db8:10:29::1")
+	pcapSourceMac  = []byte{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01}
+	pcapTargetMac  = []byte{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02}
+)
+
+// source ports of the simulated exporters in pcap files, one per exporter
+const (
+	pcapFirstSourcePort = 50000
+	pcapMaxExporters    = math.MaxUint16 - pcapFirstSourcePort + 1
+)
+
+const (
+	pcapMagic        = 0xa1b23c4d // nanosecond timestamps
+	pcapSnapLen      = 65535
+	pcapMaxSnapLen   = 262144 // largest record length accepted by libpcap
+	pcapLinkNull     = 0
+	pcapLinkEthernet = 1
+	pcapLinkRaw      = 101
+	pcapLinkLinuxSLL = 113
+)
+
+// pcapFile writes the udp datagrams of all the workers to a pcap file,
+// wrapped in synthetic ethernet, ip and udp headers
+type pcapFile struct {
+	mutex sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	ipID  uint16
+}
+
+func createPcapFile(path string) (*pcapFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &pcapFile{
+		file: file,
+		w:    bufio.NewWriter(file),
+	}
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkEthernet)
+	if _, err := p.w.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// write a datagram sent at t from src to dst
+func (p *pcapFile) write(t time.Time, src, dst *net.UDPAddr, payload []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.ipID++
+	frame := ethernetFrame(src, dst, payload, p.ipID)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+	if _, err := p.w.Write(record); err != nil {
+		return err
+	}
+	_, err := p.w.Write(frame)
+	return err
+}
+
+func (p *pcapFile) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.w.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+// ethernet frame of an udp datagram, ipv4 or ipv6 depending on dst
+func ethernetFrame(src, dst *net.UDPAddr, payload []byte, ipID uint16) []byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[8:], payload)
+
+	frame := append([]byte{}, pcapTargetMac...)
+	frame = append(frame, pcapSourceMac...)
+
+	if dst.IP.To4() != nil {
+		ip := make([]byte, 20)
+		ip[0] = 0x45 // version 4, 5 words header
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(udp)))
+		binary.BigEndian.PutUint16(ip[4:], ipID)
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = 64                                 // ttl
+		ip[9] = 17                                 // udp
+		copy(ip[12:], src.IP.To4())
+		copy(ip[16:], dst.IP.To4())
+		binary.BigEndian.PutUint16(ip[10:], internetChecksum(ip))
+
+		pseudo := append(append([]byte{}, ip[12:20]...), 0, 17, byte(len(udp)>>8), byte(len(udp)))
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+
+		frame = append(frame, 0x08, 0x00)
+		frame = append(frame, ip...)
+	} else {
+		ip := make([]byte, 40)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+		ip[6] = 17 // udp
+		ip[7] = 64 // hop limit
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+
+		pseudo := make([]byte, 40)
+		copy(pseudo, ip[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(udp)))
+		pseudo[39] = 17
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+
+		frame = append(frame, 0x86, 0xdd)
+		frame = append(frame, ip...)
+	}
+	return append(frame, udp...)
+}
+
+// udp checksum over the pseudo header, 0 is sent as 0xffff - RFC768
+func udpChecksum(pseudo, udp []byte) uint16 {
+	sum := internetChecksum(append(append([]byte{}, pseudo...), udp...))
+	if sum == 0 {
+		return 0xffff
+	}
+	return sum
+}
+
+// internet checksum - RFC1071
+func internetChecksum(b []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// source address of an exporter in pcap files, as if each exporter had its own
+// socket bound to its source ip when set. A source ip of the other address
+// family than the target is ignored so frames never mix families.
+func pcapSource(id int, local net.IP, dst *net.UDPAddr) *net.UDPAddr {
+	ip := pcapSourceIPv4
+	if dst.IP.To4() == nil {
+		ip = pcapSourceIPv6
+	}
+	if local != nil && (local.To4() != nil) == (dst.IP.To4() != nil) {
+		ip = local
+	}
+	return &net.UDPAddr{IP: ip, Port: pcapFirstSourcePort + id}
+}
+
+// pcapReader reads the udp datagrams of a classic pcap file
+type pcapReader struct {
+	file      *os.File
+	r         *bufio.Reader
+	order     binary.ByteOrder
+	nanos     bool // timestamps in nanoseconds instead of microseconds
+	linkType  uint32
+	snapLen   uint32 // largest record length of the file
+	truncated int    // datagrams skipped because they were truncated or fragmented
+}
+
+func openPcapFile(path string) (*pcapReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &pcapReader{
+		file: file,
+		r:    bufio.NewReader(file),
+	}
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading pcap header: %v", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(header) == 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == 0xa1b2c3d4:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == pcapMagic:
+		p.order, p.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == pcapMagic:
+		p.order, p.nanos = binary.BigEndian, true
+	default:
+		file.Close()
+		return nil, fmt.Errorf("%s is not a pcap file, pcapng files must be converted first", path)
+	}
+	p.snapLen = p.order.Uint32(header[16:])
+	if p.snapLen == 0 || p.snapLen > pcapMaxSnapLen {
+		p.snapLen = pcapMaxSnapLen
+	}
+	p.linkType = p.order.Uint32(header[20:])
+	switch p.linkType {
+	case pcapLinkNull, pcapLinkEthernet, pcapLinkRaw, pcapLinkLinuxSLL:
+	default:
+		file.Close()
+		return nil, fmt.Errorf("link type %d of %s is not supported", p.linkType, path)
+	}
+	return p, nil
+}
+
+// next returns the next udp datagram of the file and the time it was
+// captured, io.EOF at the end of the file
+func (p *pcapReader) next() (time.Time, *net.UDPAddr, *net.UDPAddr, []byte, error) {
+	for {
+		record := make([]byte, 16)
+		if _, err := io.ReadFull(p.r, record); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return time.Time{}, nil, nil, nil, fmt.Errorf("truncated pcap record")
+			}
+			return time.Time{}, nil, nil, nil, err
+		}
+		fraction := time.Duration(p.order.Uint32(record[4:]))
+		if !p.nanos {
+			fraction *= time.Microsecond
+		}
+		t := time.Unix(int64(p.order.Uint32(record[0:])), int64(fraction))
+
+		// a corrupted length must not allocate gigabytes
+		length := p.order.Uint32(record[8:])
+		if length > p.snapLen {
+			return time.Time{}, nil, nil, nil, fmt.Errorf("pcap record of %d bytes is larger than the snapshot length %d", length, p.snapLen)
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(p.r, frame); err != nil {
+			return time.Time{}, nil, nil, nil, fmt.Errorf("truncated pcap record")
+		}
+		if length < p.order.Uint32(record[12:]) {
+			p.truncated++
+			continue
+		}
+
+		src, dst, payload, ok := p.udpDatagram(frame)
+		if ok {
+			return t, src, dst, payload, nil
+		}
+	}
+}
+
+// udp datagram of a frame, false for other packets
+func (p *pcapReader) udpDatagram(frame []byte) (*net.UDPAddr, *net.UDPAddr, []byte, bool) {
+	var etherType uint16
+	switch p.linkType {
+	case pcapLinkNull:
+		if len(frame) < 4 {
+			return nil, nil, nil, false
+		}
+		etherType, frame = 0, frame[4:]
+	case pcapLinkEthernet:
+		if len(frame) < 14 {
+			return nil, nil, nil, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(frame) >= 4 {
+			etherType, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case pcapLinkLinuxSLL:
+		if len(frame) < 16 {
+			return nil, nil, nil, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	}
+	if len(frame) == 0 {
+		return nil, nil, nil, false
+	}
+
+	var src, dst net.IP
+	switch {
+	case (etherType == 0x0800 || etherType == 0) && frame[0]>>4 == 4:
+		headerLength := int(frame[0]&0x0f) * 4
+		if len(frame) < headerLength || headerLength < 20 || frame[9] != 17 {
+			return nil, nil, nil, false
+		}
+		if binary.BigEndian.Uint16(frame[6:])&0x3fff != 0 {
+			// fragments can't be replayed one by one
+			p.truncated++
+			return nil, nil, nil, false
+		}
+		src, dst = net.IP(frame[12:16]), net.IP(frame[16:20])
+		frame = frame[headerLength:]
+	case (etherType == 0x86dd || etherType == 0) && frame[0]>>4 == 6:
+		if len(frame) < 40 || frame[6] != 17 {
+			return nil, nil, nil, false
+		}
+		src, dst = net.IP(frame[8:24]), net.IP(frame[24:40])
+		frame = frame[40:]
+	default:
+		return nil, nil, nil, false
+	}
+
+	if len(frame) < 8 {
+		return nil, nil, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(frame[4:]))
+	if length < 8 || length > len(frame) {
+		p.truncated++
+		return nil, nil, nil, false
+	}
+	srcAddr := &net.UDPAddr{IP: src, Port: int(binary.BigEndian.Uint16(frame[0:]))}
+	dstAddr := &net.UDPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(frame[2:]))}
+	return srcAddr, dstAddr, frame[8:length], true
+}
+
+func (p *pcapReader) close() error {
+	return p.file.Close()
+}
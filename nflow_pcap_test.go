@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestPcap writes datagrams to a pcap file of a temporary directory
+func writeTestPcap(t *testing.T, payloads ...[]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.pcap")
+	p, err := createPcapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4739}
+	dst := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2055}
+	for _, payload := range payloads {
+		if err := p.write(time.Unix(1640995200, 0), src, dst, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPcapRoundTrip(t *testing.T) {
+	payloads := [][]byte{[]byte("first"), []byte("second")}
+	r, err := openPcapFile(writeTestPcap(t, payloads...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	for _, want := range payloads {
+		_, src, dst, payload, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(payload, want) || src.Port != 4739 || dst.Port != 2055 {
+			t.Errorf("read %q from %s to %s, want %q from port 4739 to 2055", payload, src, dst, want)
+		}
+	}
+	if _, _, _, _, err := r.next(); err != io.EOF {
+		t.Errorf("got %v at the end of the file, want io.EOF", err)
+	}
+}
+
+func TestPcapRecordLargerThanSnapLen(t *testing.T) {
+	path := writeTestPcap(t, []byte("datagram"))
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// corrupt the included length of the first record
+	binary.LittleEndian.PutUint32(content[24+8:], 0x7fffffff)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openPcapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	if _, _, _, _, err := r.next(); err == nil || !strings.Contains(err.Error(), "snapshot length") {
+		t.Errorf("got %v, want an error about the snapshot length", err)
+	}
+}
+
+func TestPcapSourceAddressFamily(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2055}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2055}
+	tests := []struct {
+		local net.IP
+		dst   *net.UDPAddr
+		want  net.IP
+	}{
+		{nil, v4, pcapSourceIPv4},
+		{nil, v6, pcapSourceIPv6},
+		{net.ParseIP("192.168.0.1"), v4, net.ParseIP("192.168.0.1")},
+		{net.ParseIP("2001:db8::1"), v6, net.ParseIP("2001:db8::1")},
+		// a source ip of the other family is ignored
+		{net.ParseIP("2001:db8::1"), v4, pcapSourceIPv4},
+		{net.ParseIP("192.168.0.1"), v6, pcapSourceIPv6},
+	}
+	for _, tt := range tests {
+		if got := pcapSource(1, tt.local, tt.dst); !got.IP.Equal(tt.want) {
+			t.Errorf("source of %s to %s is %s, want %s", tt.local, tt.dst, got.IP, tt.want)
+		}
+	}
+}
+
+func TestPcapSourcePorts(t *testing.T) {
+	dst := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2055}
+	if port := pcapSource(pcapMaxExporters-1, nil, dst).Port; port != math.MaxUint16 {
+		t.Errorf("last exporter sends from port %d, want %d", port, math.MaxUint16)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"nflow-generator/clock"
+	"nflow-generator/ipfix"
+	"nflow-generator/v9"
+	"time"
+)
+
+var replayOpts struct {
+	CapturePort int     `long:"capture-port" description:"udp destination port of the captured exports to replay. Default: all udp datagrams"`
+	Speed       float64 `long:"speed" description:"replay speed relative to the capture, 0 to replay as fast as possible. Default: 1"`
+	Rewrite     bool    `long:"rewrite" description:"rewrite export timestamps to now and sequence numbers to start from 0"`
+	Args        struct {
+		File string `positional-arg-name:"FILE" description:"pcap file holding the captured exports"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// replayPcap sends the datagrams of the pcap file to the collectors, paced
+// like they were captured, until the end of the file or the context is done.
+// It returns true when the end of the file is reached.
+func replayPcap(ctx context.Context, r *rand.Rand) bool {
+	reader, err := openPcapFile(replayOpts.Args.File)
+	if err != nil {
+		log.Fatal("Error opening pcap file: ", err)
+	}
+	defer reader.close()
+
+	senders := connectTargets(0, sourceIP(0))
+	for _, s := range senders {
+		defer s.close()
+	}
+
+	rewriter := newExportRewriter()
+	counter := newRecordCounter()
+	var first time.Time
+	var start time.Time
+	for ctx.Err() == nil {
+		t, src, dst, payload, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Error reading pcap file: ", err)
+		}
+		if replayOpts.CapturePort != 0 && dst.Port != replayOpts.CapturePort {
+			continue
+		}
+
+		if first.IsZero() {
+			first, start = t, time.Now()
+		}
+		if replayOpts.Speed > 0 {
+			offset := time.Duration(float64(t.Sub(first)) / replayOpts.Speed)
+			if !sleep(ctx, time.Until(start.Add(offset))) {
+				return false
+			}
+		}
+
+		if replayOpts.Rewrite {
+			payload = rewriter.rewrite(src, payload)
+		}
+		flowCount := counter.count(src, payload)
+
+		for _, p := range copies(packetDistribution.pick(r), payload, nil, flowCount, 0, nil) {
+			if sendBudget != nil && !sendBudget.take(p.flowCount) {
+				return false
+			}
+			if _, ok := sendPacket(ctx, 0, senders[p.target], p.byteArray, nil, p.flowCount); !ok {
+				return false
+			}
+		}
+
+		clock.Tick()
+	}
+
+	if reader.truncated > 0 {
+		log.Warnf("%d truncated or fragmented datagrams were skipped", reader.truncated)
+	}
+	return ctx.Err() == nil
+}
+
+// recordCounter counts the flow records of replayed exports, learning the
+// templates of each captured exporter to find the data records of ipfix
+// messages and to tell the options records of netflow v9 exports from flows
+type recordCounter struct {
+	ipfix        map[string]*ipfix.Decoder
+	ipfixOptions map[exportStream]map[uint16]bool   // ipfix options template ids
+	v9Options    map[exportStream]map[uint16]uint16 // v9 options record length by template id
+}
+
+func newRecordCounter() *recordCounter {
+	return &recordCounter{
+		ipfix:        map[string]*ipfix.Decoder{},
+		ipfixOptions: map[exportStream]map[uint16]bool{},
+		v9Options:    map[exportStream]map[uint16]uint16{},
+	}
+}
+
+// count returns the number of flow records of a netflow v5, v9 or ipfix
+// export, other payloads and records of unknown templates count for 0
+func (c *recordCounter) count(src *net.UDPAddr, payload []byte) int {
+	if len(payload) < 4 {
+		return 0
+	}
+	switch binary.BigEndian.Uint16(payload) {
+	case 5:
+		return int(binary.BigEndian.Uint16(payload[2:]))
+	case 9:
+		return c.countV9(src, payload)
+	case 10:
+		return c.countIPFIX(src, payload)
+	}
+	return 0
+}
+
+// countIPFIX returns the number of data records of an ipfix message that
+// are not options data
+func (c *recordCounter) countIPFIX(src *net.UDPAddr, payload []byte) int {
+	decoder, found := c.ipfix[src.String()]
+	if !found {
+		decoder = ipfix.NewDecoder()
+		c.ipfix[src.String()] = decoder
+	}
+	msg, err := decoder.Decode(payload)
+	if err != nil {
+		return 0
+	}
+
+	stream := exportStream{source: src.String(), version: 10, domain: msg.Header.DomainID}
+	options := c.ipfixOptions[stream]
+	for _, set := range msg.OptionsTemplateSet {
+		for _, tpl := range set.OptionTemplates {
+			if options == nil {
+				options = map[uint16]bool{}
+				c.ipfixOptions[stream] = options
+			}
+			options[tpl.ID] = true
+		}
+	}
+
+	records := 0
+	for _, set := range msg.DataSet {
+		if !options[set.Header.ID] {
+			records += len(set.Records)
+		}
+	}
+	return records
+}
+
+// countV9 returns the record count of a netflow v9 export header minus its
+// template, options template and options data records
+func (c *recordCounter) countV9(src *net.UDPAddr, payload []byte) int {
+	if len(payload) < 20 {
+		return 0
+	}
+	records := int(binary.BigEndian.Uint16(payload[2:]))
+	stream := exportStream{source: src.String(), version: 9, domain: binary.BigEndian.Uint32(payload[16:])}
+	options := c.v9Options[stream]
+
+	b := payload[20:]
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b)
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || length > len(b) {
+			break
+		}
+		body := b[4:length]
+		b = b[length:]
+
+		switch {
+		case id == v9.TEMPLATE_FLOWSET_ID:
+			//template id, field count and 4 bytes per field
+			for len(body) >= 4 {
+				fieldCount := int(binary.BigEndian.Uint16(body[2:]))
+				if 4+4*fieldCount > len(body) {
+					break
+				}
+				body = body[4+4*fieldCount:]
+				records--
+			}
+		case id == v9.OPTIONS_TEMPLATE_FLOWSET_ID:
+			//template id, scope and option lengths, then 4 bytes per field,
+			//the remaining bytes of a flowset smaller than a template are padding
+			for len(body) >= 6 {
+				fieldsLength := int(binary.BigEndian.Uint16(body[2:])) + int(binary.BigEndian.Uint16(body[4:]))
+				if fieldsLength == 0 || 6+fieldsLength > len(body) {
+					break
+				}
+				recordLength := 0
+				for i := 6; i < 6+fieldsLength; i += 4 {
+					recordLength += int(binary.BigEndian.Uint16(body[i+2:]))
+				}
+				if options == nil {
+					options = map[uint16]uint16{}
+					c.v9Options[stream] = options
+				}
+				options[binary.BigEndian.Uint16(body)] = uint16(recordLength)
+				body = body[6+fieldsLength:]
+				records--
+			}
+		default:
+			//padding is shorter than a record
+			if recordLength := int(options[id]); recordLength > 0 {
+				records -= len(body) / recordLength
+			}
+		}
+	}
+
+	if records < 0 {
+		return 0
+	}
+	return records
+}
+
+// stream of exports of a captured exporter
+type exportStream struct {
+	source  string
+	version uint16
+	domain  uint32 // engine type and id for v5, source id for v9, observation domain for ipfix
+}
+
+// exportRewriter updates the headers of replayed exports as if they were
+// sent now by exporters that just started
+type exportRewriter struct {
+	firstSeqNums map[exportStream]uint32
+}
+
+func newExportRewriter() *exportRewriter {
+	return &exportRewriter{
+		firstSeqNums: map[exportStream]uint32{},
+	}
+}
+
+// rewrite returns a copy of a netflow v5, v9 or ipfix export with the export
+// time set to now and the sequence number rebased on the first export of its
+// stream, other payloads are returned unchanged
+func (w *exportRewriter) rewrite(src *net.UDPAddr, payload []byte) []byte {
+	if len(payload) < 2 {
+		return payload
+	}
+	b := append([]byte{}, payload...)
+	now := clock.Now()
+	stream := exportStream{
+		source:  src.String(),
+		version: binary.BigEndian.Uint16(b),
+	}
+
+	var seqOffset int
+	switch stream.version {
+	case 5:
+		if len(b) < 24 {
+			return payload
+		}
+		binary.BigEndian.PutUint32(b[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(b[12:], uint32(now.Nanosecond()))
+		stream.domain = uint32(binary.BigEndian.Uint16(b[20:]))
+		seqOffset = 16
+	case 9:
+		if len(b) < 20 {
+			return payload
+		}
+		binary.BigEndian.PutUint32(b[8:], uint32(now.Unix()))
+		stream.domain = binary.BigEndian.Uint32(b[16:])
+		seqOffset = 12
+	case 10:
+		if len(b) < 16 {
+			return payload
+		}
+		binary.BigEndian.PutUint32(b[4:], uint32(now.Unix()))
+		stream.domain = binary.BigEndian.Uint32(b[12:])
+		seqOffset = 8
+	default:
+		return payload
+	}
+
+	seqNo := binary.BigEndian.Uint32(b[seqOffset:])
+	first, found := w.firstSeqNums[stream]
+	if !found {
+		first = seqNo
+		w.firstSeqNums[stream] = first
+	}
+	binary.BigEndian.PutUint32(b[seqOffset:], seqNo-first)
+	return b
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"nflow-generator/v9"
+	"testing"
+	"time"
+)
+
+var replayTestSource = &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4739}
+
+func TestReplayCountsFlowRecords(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	counter := newRecordCounter()
+
+	v5 := legacy.NewExporter(1, 0, legacy.SAMPLING_NONE, 0)
+	if got := counter.count(replayTestSource, v5.Encode(legacy.GenerateNetflow(r, 16, nil, false))); got != 16 {
+		t.Errorf("counted %d netflow v5 records, want 16", got)
+	}
+
+	// the header of v9 exports also counts the template, options template
+	// and sampling options records
+	v9Exporter := v9.NewExporter(0, legacy.SAMPLING_NONE, 0)
+	for i := 0; i < 2; i++ {
+		if got := counter.count(replayTestSource, v9Exporter.Encode(*v9.GenerateNetflow(r, 16, nil, false))); got != 16 {
+			t.Errorf("counted %d netflow v9 records, want 16", got)
+		}
+	}
+
+	// ipfix data records are only counted once their template is known
+	e := ipfix.NewExporter(time.Hour, 0, ipfix.DefaultMTU)
+	first := e.GenerateNetflow(r, 0, nil)
+	second := e.GenerateNetflow(r, 0, nil)
+	firstRecords, secondRecords := len(first.DataSet[0].Records), len(second.DataSet[0].Records)
+	if len(second.TemplateSet) != 0 {
+		t.Fatal("the second message should not announce the template again")
+	}
+	firstPayload, secondPayload := e.Encode(*first), e.Encode(*second)
+
+	other := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4739}
+	if got := counter.count(other, secondPayload); got != 0 {
+		t.Errorf("counted %d ipfix records of an unknown template, want 0", got)
+	}
+	if got := counter.count(replayTestSource, firstPayload); got != firstRecords {
+		t.Errorf("counted %d ipfix records, want %d", got, firstRecords)
+	}
+	if got := counter.count(replayTestSource, secondPayload); got != secondRecords {
+		t.Errorf("counted %d ipfix records, want %d", got, secondRecords)
+	}
+
+	if got := counter.count(replayTestSource, []byte("not an export")); got != 0 {
+		t.Errorf("counted %d records of another payload, want 0", got)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"nflow-generator/clock"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"nflow-generator/pb"
+	"nflow-generator/v9"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// packets generated per stream by the tests
+const seedTestPackets = 20
+
+var seedTestIPs = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+
+// generateStream encodes the packets of a type generated from a seed, like
+// a worker does, the simulated clock is only moved by the caller
+func generateStream(t *testing.T, flowType string, seed int64, fi bool) [][]byte {
+	t.Helper()
+	r := rand.New(rand.NewSource(seed))
+	var stream [][]byte
+	switch flowType {
+	case "legacy":
+		e := legacy.NewExporter(1, 0, legacy.SAMPLING_NONE, 0)
+		for i := 0; i < seedTestPackets; i++ {
+			stream = append(stream, e.Encode(legacy.GenerateNetflow(r, 16, seedTestIPs, fi)))
+		}
+	case "v9":
+		e := v9.NewExporter(0, legacy.SAMPLING_NONE, 0)
+		for i := 0; i < seedTestPackets; i++ {
+			stream = append(stream, e.Encode(*v9.GenerateNetflow(r, 16, seedTestIPs, fi)))
+		}
+	case "ipfix":
+		e := ipfix.NewExporter(time.Minute, 0, ipfix.DefaultMTU)
+		for i := 0; i < seedTestPackets; i++ {
+			stream = append(stream, e.Encode(*e.GenerateNetflow(r, 0, seedTestIPs)))
+		}
+	case "pb":
+		for i := 0; i < seedTestPackets; i++ {
+			for _, flow := range pb.GenerateRecords(r, seedTestIPs, 16) {
+				b, err := proto.MarshalOptions{Deterministic: true}.Marshal(flow)
+				if err != nil {
+					t.Fatal(err)
+				}
+				stream = append(stream, b)
+			}
+		}
+	default:
+		t.Fatalf("unknown type %s", flowType)
+	}
+	return stream
+}
+
+func compareStreams(t *testing.T, got, want [][]byte) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("packet %d differs:\n got %x\nwant %x", i, got[i], want[i])
+		}
+	}
+}
+
+func simulateClock(t *testing.T) {
+	t.Helper()
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock.Simulate(start, time.Millisecond)
+}
+
+func TestSeedReproducibleStreams(t *testing.T) {
+	for _, flowType := range []string{"legacy", "v9", "ipfix", "pb"} {
+		t.Run(flowType, func(t *testing.T) {
+			simulateClock(t)
+			first := generateStream(t, flowType, 42, true)
+			simulateClock(t)
+			second := generateStream(t, flowType, 42, true)
+			compareStreams(t, second, first)
+
+			simulateClock(t)
+			other := generateStream(t, flowType, 43, true)
+			if bytes.Equal(bytes.Join(other, nil), bytes.Join(first, nil)) {
+				t.Error("seeds 42 and 43 generated the same stream")
+			}
+		})
+	}
+}
+
+// workers generating with and without false indexes at the same time get
+// the streams they get alone
+func TestSeedConcurrentWorkers(t *testing.T) {
+	simulateClock(t)
+	types := []string{"legacy", "v9"}
+	want := map[string][][]byte{}
+	for _, flowType := range types {
+		for _, fi := range []bool{true, false} {
+			want[flowType+map[bool]string{true: "-fi"}[fi]] = generateStream(t, flowType, 7, fi)
+		}
+	}
+
+	for round := 0; round < 50; round++ {
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		got := map[string][][]byte{}
+		for _, flowType := range types {
+			for _, fi := range []bool{true, false} {
+				wg.Add(1)
+				go func(flowType string, fi bool) {
+					defer wg.Done()
+					stream := generateStream(t, flowType, 7, fi)
+					mutex.Lock()
+					got[flowType+map[bool]string{true: "-fi"}[fi]] = stream
+					mutex.Unlock()
+				}(flowType, fi)
+			}
+		}
+		wg.Wait()
+		for name, stream := range want {
+			compareStreams(t, got[name], stream)
+		}
+	}
+}
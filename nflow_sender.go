@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"nflow-generator/clock"
+	"time"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/grpc"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxRetryBackoff caps the exponential backoff of --on-error retry
+const maxRetryBackoff = 10 * time.Second
+
+// timeouts of the tcp and tls connections, a collector not reading its
+// stream fails the write instead of blocking the worker
+const (
+	streamDialTimeout  = 5 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+// transports of the ipfix exports
+const (
+	udpTransport = "udp"
+	tcpTransport = "tcp"
+	tlsTransport = "tls"
+)
+
+// maxUDPPayload is the largest payload of a udp datagram over ipv4
+const maxUDPPayload = 65507
+
+// sender sends packets to a collector over udp, tcp, tls or grpc, the
+// connection is closed on errors and dialed again on the next send. With a
+// pcap file the datagrams are written to it instead, and with a kafka output
+// the records are published to its topic.
+type sender struct {
+	target   string
+	grpc     bool
+	grpcConn *grpc.ClientConnection
+	udpConn  *net.UDPConn
+	local    net.IP // local address of the sockets, nil to let the system pick it
+	// ipfix messages are written to a tcp or tls stream instead of udp
+	// datagrams, starting each session with the message of onConnect
+	transport  string
+	streamConn net.Conn
+	onConnect  func() []byte
+	pcap       *pcapFile
+	pcapSrc    *net.UDPAddr
+	pcapDst    *net.UDPAddr
+	kafka      *kafkaPublisher
+	packets    int  // packets sent on the current connection
+	failing    bool // an error was logged and the collector did not recover yet
+}
+
+func newSender(target string, useGrpc bool, transport string, local net.IP) (*sender, error) {
+	s := &sender{
+		target:    target,
+		grpc:      useGrpc,
+		transport: transport,
+		local:     local,
+	}
+	return s, s.connect()
+}
+
+func newPcapSender(id int, local net.IP, target string, file *pcapFile) (*sender, error) {
+	dst, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	return &sender{
+		target:  target,
+		pcap:    file,
+		pcapSrc: pcapSource(id, local, dst),
+		pcapDst: dst,
+	}, nil
+}
+
+func (s *sender) connect() error {
+	var err error
+	s.packets = 0
+	if s.grpc {
+		s.grpcConn, err = grpc.ConnectClient(s.target)
+		return err
+	}
+	if s.transport == tcpTransport || s.transport == tlsTransport {
+		return s.connectStream()
+	}
+	addr, err := net.ResolveUDPAddr("udp", s.target)
+	if err != nil {
+		return err
+	}
+	var local *net.UDPAddr
+	if s.local != nil {
+		local = &net.UDPAddr{IP: s.local}
+	}
+	s.udpConn, err = net.DialUDP("udp", local, addr)
+	return err
+}
+
+// connectStream opens a tcp or tls session and writes its first message
+func (s *sender) connectStream() error {
+	dialer := &net.Dialer{Timeout: streamDialTimeout}
+	if s.local != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: s.local}
+	}
+	var conn net.Conn
+	var err error
+	if s.transport == tlsTransport {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.target, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.target)
+	}
+	if err != nil {
+		return err
+	}
+	s.streamConn = conn
+	if s.onConnect != nil {
+		if b := s.onConnect(); b != nil {
+			if _, err := s.write(b); err != nil {
+				s.close()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write a message to the stream, ipfix messages are framed by the length
+// of their header so they are written whole
+func (s *sender) write(b []byte) (int, error) {
+	if err := s.streamConn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return 0, err
+	}
+	return s.streamConn.Write(b)
+}
+
+// newTLSConfig builds the configuration of the tls sessions: the collector
+// is verified with the ca file, or with the system roots when not set, and
+// the certificate and key are presented for mutual authentication when set
+func newTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		// a collector verifies the certificates of the exporters with the same ca
+		config.ClientCAs = config.RootCAs
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (s *sender) close() {
+	if s.grpcConn != nil {
+		s.grpcConn.Close()
+		s.grpcConn = nil
+	}
+	if s.udpConn != nil {
+		s.udpConn.Close()
+		s.udpConn = nil
+	}
+	if s.streamConn != nil {
+		s.streamConn.Close()
+		s.streamConn = nil
+	}
+}
+
+// send writes the udp payload, the grpc records or the kafka messages and
+// returns the bytes sent
+func (s *sender) send(ctx context.Context, byteArray []byte, flows []*pbflow.Record) (int, error) {
+	if s.pcap != nil {
+		return len(byteArray), s.pcap.write(clock.Now(), s.pcapSrc, s.pcapDst, byteArray)
+	}
+	if s.kafka != nil {
+		return s.kafka.publish(ctx, flows)
+	}
+	if s.grpcConn == nil && s.udpConn == nil && s.streamConn == nil {
+		if err := s.connect(); err != nil {
+			return 0, err
+		}
+	}
+	if s.grpc {
+		records := &pbflow.Records{
+			Entries: flows,
+		}
+		_, err := s.grpcConn.Client().Send(ctx, records)
+		return proto.Size(records), err
+	}
+	if s.streamConn != nil {
+		return s.write(byteArray)
+	}
+	return s.udpConn.Write(byteArray)
+}
+
+// sendPacket sends a packet following the --on-error policy and accounts it
+// in the statistics. It returns whether the packet was written, and false
+// as ok if the context is done.
+func sendPacket(ctx context.Context, worker int, s *sender, byteArray []byte, flows []*pbflow.Record, flowCount int) (sent bool, ok bool) {
+	backoff := opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		sendStart := time.Now()
+		wireBytes, err := s.send(ctx, byteArray, flows)
+		latency := time.Since(sendStart)
+
+		if err == nil {
+			s.packets++
+			// the first udp write after a dial succeeds even if the port is
+			// unreachable, the collector is only back after a second one
+			if s.failing && s.packets > 1 {
+				log.Infof("collector %s recovered", s.target)
+				s.failing = false
+			}
+			stats.sent(worker, s.target, flowCount, wireBytes, latency)
+			return true, true
+		}
+		if ctx.Err() != nil {
+			return false, false
+		}
+
+		stats.failed(worker, s.target, latency)
+		if opts.OnError == "fail" {
+			stats.logSummary()
+			log.Fatal("Error connecting to the target collector: ", err)
+		}
+		if !s.failing {
+			// log once until the collector recovers, errors are counted in the statistics
+			log.Warnf("Error sending to collector %s, reconnecting: %v", s.target, err)
+			s.failing = true
+		}
+		s.close()
+
+		if opts.OnError == "skip" || attempt >= opts.Retries {
+			return false, true
+		}
+		if !sleep(ctx, backoff) {
+			return false, false
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
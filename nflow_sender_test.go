@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// withOnError sets the error policy and the statistics of a test
+func withOnError(t *testing.T, policy string, targets ...string) {
+	t.Helper()
+	saved, savedStats := opts, stats
+	opts.OnError, opts.Retries, opts.RetryBackoff = policy, 1, time.Millisecond
+	stats = newStatistics(1, targets)
+	t.Cleanup(func() { opts, stats = saved, savedStats })
+}
+
+func TestSendPacket(t *testing.T) {
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	reachable := listener.LocalAddr().String()
+	const unresolvable = "127.0.0.1:not-a-port"
+
+	tests := []struct {
+		policy   string
+		target   string
+		sent, ok bool
+		errors   uint64
+	}{
+		{"skip", reachable, true, true, 0},
+		{"skip", unresolvable, false, true, 1},
+		{"retry", unresolvable, false, true, 2}, // the first attempt and one retry
+	}
+	for _, tt := range tests {
+		withOnError(t, tt.policy, tt.target)
+		s := &sender{target: tt.target, transport: udpTransport}
+		sent, ok := sendPacket(context.Background(), 0, s, []byte("packet"), nil, 1)
+		if sent != tt.sent || ok != tt.ok {
+			t.Errorf("%s to %s: got sent %v ok %v, want sent %v ok %v", tt.policy, tt.target, sent, ok, tt.sent, tt.ok)
+		}
+		if errors := stats.total().errors; errors != tt.errors {
+			t.Errorf("%s to %s: counted %d errors, want %d", tt.policy, tt.target, errors, tt.errors)
+		}
+		s.close()
+	}
+}
+
+func TestSendPacketCanceled(t *testing.T) {
+	withOnError(t, "retry", "127.0.0.1:not-a-port")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &sender{target: "127.0.0.1:not-a-port", transport: udpTransport}
+	if sent, ok := sendPacket(ctx, 0, s, []byte("packet"), nil, 1); sent || ok {
+		t.Errorf("got sent %v ok %v on a canceled context, want false false", sent, ok)
+	}
+}
+
+func TestConnectTargetsUnreachable(t *testing.T) {
+	const unresolvable = "127.0.0.1:not-a-port"
+	withOnError(t, "skip", unresolvable)
+	saved := targets
+	targets = []string{unresolvable}
+	t.Cleanup(func() { targets = saved })
+	opts.Transport = udpTransport
+
+	senders := connectTargets(0, nil)
+	if len(senders) != 1 || !senders[0].failing || senders[0].udpConn != nil {
+		t.Fatalf("got senders %+v, want an unconnected failing sender", senders)
+	}
+	if sent, ok := sendPacket(context.Background(), 0, senders[0], []byte("packet"), nil, 1); sent || !ok {
+		t.Errorf("got sent %v ok %v, want the packet skipped", sent, ok)
+	}
+}
+
+// a tcp collector started after the generator gets the packets once it listens
+func TestConnectTargetsStreamDownAtStartup(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := listener.Addr().String()
+	listener.Close()
+
+	withOnError(t, "retry", target)
+	saved := targets
+	targets = []string{target}
+	t.Cleanup(func() { targets = saved })
+	opts.Transport = tcpTransport
+
+	senders := connectTargets(0, nil)
+	if senders[0].streamConn != nil {
+		t.Fatal("connected to a collector that is down")
+	}
+
+	listener, err = net.Listen("tcp", target)
+	if err != nil {
+		t.Skipf("port of the collector was reused: %v", err)
+	}
+	defer listener.Close()
+	if sent, ok := sendPacket(context.Background(), 0, senders[0], []byte("packet"), nil, 1); !sent || !ok {
+		t.Errorf("got sent %v ok %v, want the packet sent once the collector listens", sent, ok)
+	}
+	senders[0].close()
+}
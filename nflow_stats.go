@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// counters are updated atomically by the workers, or by the collector
+// for the exports it receives
+type counters struct {
+	packets uint64
+	records uint64
+	bytes   uint64 // bytes on the wire
+	errors  uint64 // send errors, or exports the collector could not decode
+}
+
+// add counts a packet sent by a worker or an export decoded by the collector
+func (c *counters) add(records, bytes int) {
+	atomic.AddUint64(&c.packets, 1)
+	atomic.AddUint64(&c.records, uint64(records))
+	atomic.AddUint64(&c.bytes, uint64(bytes))
+}
+
+func (c *counters) failed() {
+	atomic.AddUint64(&c.errors, 1)
+}
+
+func (c *counters) snapshot() counters {
+	return counters{
+		packets: atomic.LoadUint64(&c.packets),
+		records: atomic.LoadUint64(&c.records),
+		bytes:   atomic.LoadUint64(&c.bytes),
+		errors:  atomic.LoadUint64(&c.errors),
+	}
+}
+
+func (c counters) minus(o counters) counters {
+	return counters{
+		packets: c.packets - o.packets,
+		records: c.records - o.records,
+		bytes:   c.bytes - o.bytes,
+		errors:  c.errors - o.errors,
+	}
+}
+
+func (c counters) String() string {
+	return fmt.Sprintf("%d packets, %d records, %d bytes, %d errors", c.packets, c.records, c.bytes, c.errors)
+}
+
+// receivedString describes the counters of the collector, whose errors are
+// exports it could not decode
+func (c counters) receivedString() string {
+	return fmt.Sprintf("%d packets, %d records, %d bytes, %d invalid", c.packets, c.records, c.bytes, c.errors)
+}
+
+// statistics keeps counters per worker and per collector, both sets are
+// allocated upfront so that workers only do atomic operations
+type statistics struct {
+	start      time.Time
+	workers    []*counters
+	collectors map[string]*counters
+	metrics    *metrics // nil unless --metrics-addr is set
+}
+
+func newStatistics(workers int, targets []string) *statistics {
+	s := &statistics{
+		start:      time.Now(),
+		collectors: map[string]*counters{},
+	}
+	for i := 0; i < workers; i++ {
+		s.workers = append(s.workers, &counters{})
+	}
+	for _, target := range targets {
+		s.collectors[target] = &counters{}
+	}
+	return s
+}
+
+// sent accounts a packet of records sent by a worker to a collector
+func (s *statistics) sent(worker int, target string, records, bytes int, latency time.Duration) {
+	s.workers[worker].add(records, bytes)
+	s.collectors[target].add(records, bytes)
+	if s.metrics != nil {
+		s.metrics.sent(target, records, bytes, latency)
+	}
+}
+
+// failed accounts a send error of a worker to a collector
+func (s *statistics) failed(worker int, target string, latency time.Duration) {
+	s.workers[worker].failed()
+	s.collectors[target].failed()
+	if s.metrics != nil {
+		s.metrics.failed(target, latency)
+	}
+}
+
+func (s *statistics) total() counters {
+	var t counters
+	for _, w := range s.workers {
+		c := w.snapshot()
+		t.packets += c.packets
+		t.records += c.records
+		t.bytes += c.bytes
+		t.errors += c.errors
+	}
+	return t
+}
+
+// report logs the rates over every interval until the context is done
+func (s *statistics) report(ctx context.Context, interval time.Duration) {
+	previous := s.total()
+	for {
+		if !sleep(ctx, interval) {
+			return
+		}
+
+		current := s.total()
+		delta := current.minus(previous)
+		previous = current
+
+		seconds := interval.Seconds()
+		log.Infof("Current rate is: %.1f packets, %.1f records, %.1f bytes per second, %d errors",
+			float64(delta.packets)/seconds, float64(delta.records)/seconds, float64(delta.bytes)/seconds, delta.errors)
+	}
+}
+
+// logSummary logs the totals since start, per worker and per collector
+func (s *statistics) logSummary() {
+	elapsed := time.Since(s.start)
+	total := s.total()
+	log.Infof("Sent %s in %s (%.1f records per second)", total, elapsed.Round(time.Millisecond), float64(total.records)/elapsed.Seconds())
+	for i, w := range s.workers {
+		log.Infof("  worker %d: %s", i, w.snapshot())
+	}
+
+	var targets []string
+	for target := range s.collectors {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		log.Infof("  collector %s: %s", target, s.collectors[target].snapshot())
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountersConcurrentAdd(t *testing.T) {
+	c := &counters{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.add(16, 1000)
+			}
+			c.failed()
+		}()
+	}
+	wg.Wait()
+	want := counters{packets: 8000, records: 128000, bytes: 8000000, errors: 8}
+	if got := c.snapshot(); got != want {
+		t.Errorf("counted %s, want %s", got, want)
+	}
+}
+
+func TestCountersMinus(t *testing.T) {
+	current := counters{packets: 10, records: 160, bytes: 14000, errors: 2}
+	previous := counters{packets: 4, records: 64, bytes: 5600, errors: 1}
+	want := counters{packets: 6, records: 96, bytes: 8400, errors: 1}
+	if got := current.minus(previous); got != want {
+		t.Errorf("delta %s, want %s", got, want)
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	s := newStatistics(2, []string{"10.0.0.1:2055", "10.0.0.2:2055"})
+	s.sent(0, "10.0.0.1:2055", 16, 1000, time.Millisecond)
+	s.sent(1, "10.0.0.2:2055", 8, 500, time.Millisecond)
+	s.sent(1, "10.0.0.1:2055", 8, 500, time.Millisecond)
+	s.failed(0, "10.0.0.2:2055", time.Millisecond)
+
+	tests := []struct {
+		name string
+		got  counters
+		want counters
+	}{
+		{"total", s.total(), counters{packets: 3, records: 32, bytes: 2000, errors: 1}},
+		{"worker 0", s.workers[0].snapshot(), counters{packets: 1, records: 16, bytes: 1000, errors: 1}},
+		{"worker 1", s.workers[1].snapshot(), counters{packets: 2, records: 16, bytes: 1000}},
+		{"collector 1", s.collectors["10.0.0.1:2055"].snapshot(), counters{packets: 2, records: 24, bytes: 1500}},
+		{"collector 2", s.collectors["10.0.0.2:2055"].snapshot(), counters{packets: 1, records: 8, bytes: 500, errors: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("counted %s, want %s", tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"nflow-generator/v9"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
+)
+
+// fingerprint identifies an emitted flow, timestamps are in milliseconds
+// as exported: system uptime for netflow and ipfix, unix time for pb.
+// Fields the sink doesn't hold are zeroed before comparing.
+type fingerprint struct {
+	key     string // protocol, addresses and ports
+	bytes   uint64
+	packets uint64
+	start   uint64
+	end     uint64
+}
+
+func flowKey(protocol uint64, src, dst net.IP, srcPort, dstPort uint64) string {
+	return fmt.Sprintf("%d %s:%d > %s:%d", protocol, src, srcPort, dst, dstPort)
+}
+
+func legacyFingerprints(data legacy.Netflow) []fingerprint {
+	if flowVerifier == nil {
+		return nil
+	}
+	var fingerprints []fingerprint
+	for _, record := range data.Records {
+		fingerprints = append(fingerprints, fingerprint{
+			key: flowKey(uint64(record.IpProtocol), uint32ToIP(record.SrcIP), uint32ToIP(record.DstIP),
+				uint64(record.SrcPort), uint64(record.DstPort)),
+			bytes:   uint64(record.NumOctets),
+			packets: uint64(record.NumPackets),
+			start:   uint64(record.SysUptimeStart),
+			end:     uint64(record.SysUptimeEnd),
+		})
+	}
+	return fingerprints
+}
+
+func v9Fingerprints(msg v9.Message) []fingerprint {
+	if flowVerifier == nil {
+		return nil
+	}
+	// v9 shares the element ids of ipfix for these fields
+	var fingerprints []fingerprint
+	for _, flowSet := range msg.DataFlowSet {
+		if flowSet.Header.ID == v9.OptionsTemplateID {
+			continue
+		}
+		for _, record := range flowSet.Records {
+			var fields []ipfix.DataField
+			for _, field := range record {
+				fields = append(fields, ipfix.DataField{FieldID: field.FieldType, Value: field.Value})
+			}
+			fingerprints = append(fingerprints, elementsFingerprint(fields))
+		}
+	}
+	return fingerprints
+}
+
+func ipfixFingerprints(msg ipfix.Message) []fingerprint {
+	if flowVerifier == nil {
+		return nil
+	}
+	var fingerprints []fingerprint
+	for _, dataSet := range msg.DataSet {
+		for _, record := range dataSet.Records {
+			fingerprints = append(fingerprints, elementsFingerprint(record))
+		}
+	}
+	return fingerprints
+}
+
+// fingerprint of a record made of ipfix information elements
+func elementsFingerprint(record []ipfix.DataField) fingerprint {
+	var f fingerprint
+	var protocol, srcPort, dstPort uint64
+	var src, dst net.IP
+	for _, field := range record {
+		switch field.FieldID {
+		case 4:
+			protocol = elementUint(field.Value)
+		case 7:
+			srcPort = elementUint(field.Value)
+		case 11:
+			dstPort = elementUint(field.Value)
+		case 8, 27:
+			src = elementIP(field.Value)
+		case 12, 28:
+			dst = elementIP(field.Value)
+		case 1:
+			f.bytes = elementUint(field.Value)
+		case 2:
+			f.packets = elementUint(field.Value)
+		case 22, 152: //flowStartSysUpTime, flowStartMilliseconds
+			f.start = elementUint(field.Value)
+		case 21, 153: //flowEndSysUpTime, flowEndMilliseconds
+			f.end = elementUint(field.Value)
+		case 150: //flowStartSeconds
+			f.start = elementUint(field.Value) * 1000
+		case 151: //flowEndSeconds
+			f.end = elementUint(field.Value) * 1000
+		}
+	}
+	f.key = flowKey(protocol, src, dst, srcPort, dstPort)
+	return f
+}
+
+// elementUint returns the value of an unsigned element, encoded in network
+// order or decoded
+func elementUint(value interface{}) uint64 {
+	switch v := value.(type) {
+	case []byte:
+		n := uint64(0)
+		for _, b := range v {
+			n = n<<8 | uint64(b)
+		}
+		return n
+	case uint8:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	}
+	return 0
+}
+
+func elementIP(value interface{}) net.IP {
+	switch v := value.(type) {
+	case net.IP:
+		return v
+	case []byte:
+		return net.IP(v)
+	}
+	return nil
+}
+
+func pbFingerprints(flows []*pbflow.Record) []fingerprint {
+	if flowVerifier == nil {
+		return nil
+	}
+	var fingerprints []fingerprint
+	for _, flow := range flows {
+		var ips []net.IP
+		for _, ip := range []*pbflow.IP{flow.GetNetwork().GetSrcAddr(), flow.GetNetwork().GetDstAddr()} {
+			if v6 := ip.GetIpv6(); v6 != nil {
+				ips = append(ips, net.IP(v6))
+			} else {
+				ips = append(ips, uint32ToIP(ip.GetIpv4()))
+			}
+		}
+		transport := flow.GetTransport()
+		fingerprints = append(fingerprints, fingerprint{
+			key: flowKey(uint64(transport.GetProtocol()), ips[0], ips[1],
+				uint64(transport.GetSrcPort()), uint64(transport.GetDstPort())),
+			bytes:   flow.Bytes,
+			packets: flow.Packets,
+			start:   uint64(flow.GetTimeFlowStart().AsTime().UnixNano() / int64(time.Millisecond)),
+			end:     uint64(flow.GetTimeFlowEnd().AsTime().UnixNano() / int64(time.Millisecond)),
+		})
+	}
+	return fingerprints
+}
+
+// names of the fingerprint fields in the records of a sink: ipfix elements
+// as printed by the collect command, then the names used by netobserv and
+// goflow collectors
+var (
+	protocolNames = []string{"protocolIdentifier", "Proto"}
+	srcNames      = []string{"sourceIPv4Address", "sourceIPv6Address", "SrcAddr"}
+	dstNames      = []string{"destinationIPv4Address", "destinationIPv6Address", "DstAddr"}
+	srcPortNames  = []string{"sourceTransportPort", "SrcPort"}
+	dstPortNames  = []string{"destinationTransportPort", "DstPort"}
+	bytesNames    = []string{"octetDeltaCount", "Bytes"}
+	packetsNames  = []string{"packetDeltaCount", "Packets"}
+	startNames    = []string{"flowStartSysUpTime", "flowStartMilliseconds", "TimeFlowStartMs"}
+	endNames      = []string{"flowEndSysUpTime", "flowEndMilliseconds", "TimeFlowEndMs"}
+)
+
+// sinkRecord is a flow read back from a sink
+type sinkRecord map[string]interface{}
+
+func (r sinkRecord) lookup(names []string) (interface{}, bool) {
+	for _, name := range names {
+		if v, found := r[name]; found {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (r sinkRecord) uint(names []string) uint64 {
+	v, _ := r.lookup(names)
+	switch v := v.(type) {
+	case json.Number:
+		n, err := strconv.ParseUint(v.String(), 10, 64)
+		if err != nil {
+			f, _ := v.Float64()
+			return uint64(f)
+		}
+		return n
+	case string:
+		n, _ := strconv.ParseUint(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func (r sinkRecord) ip(names []string) net.IP {
+	v, _ := r.lookup(names)
+	s, _ := v.(string)
+	return net.ParseIP(s)
+}
+
+func (r sinkRecord) fingerprint() fingerprint {
+	return fingerprint{
+		key: flowKey(r.uint(protocolNames), r.ip(srcNames), r.ip(dstNames),
+			r.uint(srcPortNames), r.uint(dstPortNames)),
+		bytes:   r.uint(bytesNames),
+		packets: r.uint(packetsNames),
+		start:   r.uint(startNames),
+		end:     r.uint(endNames),
+	}
+}
+
+// verifier records the fingerprints of the flows sent to the collectors
+type verifier struct {
+	mutex   sync.Mutex
+	sink    string
+	timeout time.Duration
+	sent    []fingerprint
+}
+
+func newVerifier(sink string, timeout time.Duration) (*verifier, error) {
+	switch {
+	case strings.HasPrefix(sink, "file:"), strings.HasPrefix(sink, "kafka:"),
+		strings.HasPrefix(sink, "http://"), strings.HasPrefix(sink, "https://"):
+	default:
+		return nil, fmt.Errorf("sink %s is not valid, use 'file:/path/records.jsonl', 'kafka:/path/topic.dump' or an http url", sink)
+	}
+	return &verifier{
+		sink:    sink,
+		timeout: timeout,
+	}, nil
+}
+
+// add the fingerprints of a packet sent to a collector
+func (v *verifier) add(fingerprints []fingerprint) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.sent = append(v.sent, fingerprints...)
+}
+
+// read the records of the sink: json lines from a file, the messages of a
+// kafka topic dumped one per line with an optional tab separated key, the
+// messages of the in-process kafka stand-in, or json lines or a json array
+// from an http endpoint
+func (v *verifier) read() ([]sinkRecord, error) {
+	var body io.Reader
+	switch {
+	case v.sink == "kafka:"+kafkaMemoryBrokers:
+		return kafkaOutput.records()
+	case strings.HasPrefix(v.sink, "http://"), strings.HasPrefix(v.sink, "https://"):
+		resp, err := http.Get(v.sink)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned %s", v.sink, resp.Status)
+		}
+		body = resp.Body
+	default:
+		path := v.sink[strings.Index(v.sink, ":")+1:]
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		body = file
+	}
+
+	r := bufio.NewReader(body)
+	if first, err := r.Peek(1); err == nil && first[0] == '[' {
+		var records []sinkRecord
+		decoder := json.NewDecoder(r)
+		decoder.UseNumber()
+		return records, decoder.Decode(&records)
+	}
+
+	var records []sinkRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if strings.HasPrefix(v.sink, "kafka:") {
+			if i := bytes.IndexByte(b, '\t'); i >= 0 {
+				b = b[i+1:]
+			}
+		}
+		if len(b) == 0 {
+			continue
+		}
+		record := sinkRecord{}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// verification is the outcome of the comparison of the sent flows with the
+// records of the sink
+type verification struct {
+	sent       int
+	received   int
+	matched    int
+	missing    int // sent flows not found
+	duplicated int // extra copies of sent flows
+	altered    int // records of a sent 5-tuple with other counters or timestamps
+	unexpected int // records of 5-tuples that were not sent
+	examples   []string
+}
+
+func (r verification) ok() bool {
+	return r.missing == 0 && r.duplicated == 0 && r.altered == 0 && r.unexpected == 0
+}
+
+// compare the sent flows with the records of the sink, flows are matched
+// on all their fields then paired by 5-tuple to find the altered ones
+func (v *verifier) compare(records []sinkRecord) verification {
+	v.mutex.Lock()
+	sent := append([]fingerprint{}, v.sent...)
+	v.mutex.Unlock()
+
+	received := make([]fingerprint, len(records))
+	var hasStart, hasEnd bool
+	for i, record := range records {
+		received[i] = record.fingerprint()
+		hasStart = hasStart || received[i].start != 0
+		hasEnd = hasEnd || received[i].end != 0
+	}
+	// timestamps are only compared when the sink holds them
+	for i := range sent {
+		if !hasStart {
+			sent[i].start = 0
+		}
+		if !hasEnd {
+			sent[i].end = 0
+		}
+	}
+
+	sentCounts := map[fingerprint]int{}
+	for _, f := range sent {
+		sentCounts[f]++
+	}
+	receivedCounts := map[fingerprint]int{}
+	for _, f := range received {
+		receivedCounts[f]++
+	}
+
+	result := verification{sent: len(sent), received: len(received)}
+	leftSent := map[string]int{}
+	leftReceived := map[string]int{}
+	for f, n := range sentCounts {
+		m := receivedCounts[f]
+		if m > n {
+			result.matched += n
+			result.duplicated += m - n
+			if len(result.examples) < 5 {
+				result.examples = append(result.examples, fmt.Sprintf("duplicated %dx: %s", m-n, f))
+			}
+		} else {
+			result.matched += m
+			leftSent[f.key] += n - m
+		}
+	}
+	for f, m := range receivedCounts {
+		if _, found := sentCounts[f]; !found {
+			leftReceived[f.key] += m
+		}
+	}
+
+	for key, n := range leftSent {
+		if n == 0 {
+			continue
+		}
+		m := leftReceived[key]
+		altered := n
+		if m < n {
+			altered = m
+		}
+		result.altered += altered
+		result.missing += n - altered
+		if altered > 0 && len(result.examples) < 5 {
+			result.examples = append(result.examples, fmt.Sprintf("%d altered: %s", altered, key))
+		}
+		if n > altered && len(result.examples) < 5 {
+			result.examples = append(result.examples, fmt.Sprintf("%d missing: %s", n-altered, key))
+		}
+	}
+	for key, m := range leftReceived {
+		if n := leftSent[key]; m > n {
+			result.unexpected += m - n
+			if len(result.examples) < 5 {
+				result.examples = append(result.examples, fmt.Sprintf("%d unexpected: %s", m-n, key))
+			}
+		}
+	}
+	return result
+}
+
+func (f fingerprint) String() string {
+	return fmt.Sprintf("%s, %d bytes, %d packets, start %d, end %d", f.key, f.bytes, f.packets, f.start, f.end)
+}
+
+// verify reads the sink until every sent flow is found or the timeout is
+// reached, logs the outcome and returns true if the sink matches
+func (v *verifier) verify(ctx context.Context) bool {
+	log.Infof("verifying %d sent flows against %s", len(v.sent), v.sink)
+	deadline := time.Now().Add(v.timeout)
+	var result verification
+	for {
+		records, err := v.read()
+		if err != nil {
+			log.Errorf("Error reading sink %s: %v", v.sink, err)
+		} else {
+			result = v.compare(records)
+			if result.missing == 0 {
+				break
+			}
+		}
+		// collectors may flush their records with some delay
+		if time.Now().After(deadline) || !sleep(ctx, time.Second) {
+			break
+		}
+	}
+
+	log.Infof("verification: %d flows sent, %d records read back, %d matched", result.sent, result.received, result.matched)
+	if result.ok() {
+		log.Info("verification passed")
+		return true
+	}
+	log.Errorf("verification failed: %d missing, %d duplicated, %d altered, %d unexpected",
+		result.missing, result.duplicated, result.altered, result.unexpected)
+	for _, example := range result.examples {
+		log.Errorf("  %s", example)
+	}
+	return false
+}
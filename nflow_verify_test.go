@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"nflow-generator/v9"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sinkRecords returns the records a collector would write for fingerprints,
+// named like netobserv does and without timestamps
+func sinkRecords(fingerprints []fingerprint) []sinkRecord {
+	var records []sinkRecord
+	for _, f := range fingerprints {
+		// keys are "protocol src:port > dst:port"
+		parts := strings.Fields(f.key)
+		src, dst := parts[1], parts[3]
+		i, j := strings.LastIndex(src, ":"), strings.LastIndex(dst, ":")
+		records = append(records, sinkRecord{
+			"Proto":   json.Number(parts[0]),
+			"SrcAddr": src[:i],
+			"DstAddr": dst[:j],
+			"SrcPort": json.Number(src[i+1:]),
+			"DstPort": json.Number(dst[j+1:]),
+			"Bytes":   json.Number(fmt.Sprint(f.bytes)),
+			"Packets": json.Number(fmt.Sprint(f.packets)),
+		})
+	}
+	return records
+}
+
+func withVerifier(t *testing.T) *verifier {
+	t.Helper()
+	v, err := newVerifier("file:/dev/null", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flowVerifier = v
+	t.Cleanup(func() { flowVerifier = nil })
+	return v
+}
+
+// the sampling options data set of v9 exports is not a flow
+func TestVerifySampledV9(t *testing.T) {
+	v := withVerifier(t)
+	r := rand.New(rand.NewSource(1))
+	e := v9.NewExporter(7, legacy.SAMPLING_RANDOM, 100)
+	var records []sinkRecord
+	for i := 0; i < 4; i++ {
+		msg := *v9.GenerateNetflow(r, 16, nil, false)
+		e.Encode(msg)
+		fingerprints := v9Fingerprints(msg)
+		if len(fingerprints) != 16 {
+			t.Fatalf("got %d fingerprints, want 16", len(fingerprints))
+		}
+		v.add(fingerprints)
+		records = append(records, sinkRecords(fingerprints)...)
+	}
+	result := v.compare(records)
+	if !result.ok() || result.matched != 64 {
+		t.Errorf("verification failed: %+v", result)
+	}
+}
+
+func TestVerifyMissingFlow(t *testing.T) {
+	v := withVerifier(t)
+	r := rand.New(rand.NewSource(1))
+	e := ipfix.NewExporter(time.Hour, 0, ipfix.DefaultMTU)
+	fingerprints := ipfixFingerprints(*e.GenerateNetflow(r, 0, nil))
+	v.add(fingerprints)
+	result := v.compare(sinkRecords(fingerprints[1:]))
+	if result.ok() || result.missing != 1 || result.matched != len(fingerprints)-1 {
+		t.Errorf("got %+v, want 1 missing flow", result)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"encoding/binary"
 	"math/rand"
 	"net"
+	"nflow-generator/clock"
+	"nflow-generator/legacy"
+	"nflow-generator/scenario"
 	"time"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
@@ -13,60 +16,138 @@ import (
 
 var (
 	directions = []pbflow.Direction{pbflow.Direction_INGRESS, pbflow.Direction_EGRESS}
+	//interfaces of a kubernetes node seen by the agent
+	interfaces = []string{"eth0", "ens5", "br-ex", "genev_sys_6081", "ovn-k8s-mp0", "veth3f2a91c"}
 )
 
-func GenerateRecords(ips []string) []*pbflow.Record {
+const (
+	ETH_P_IP   = 0x0800
+	ETH_P_IPV6 = 0x86DD
+
+	//DefaultBatchSize is the number of records sent per pbflow.Records,
+	//like the records of a netflow v5 packet
+	DefaultBatchSize = 16
+
+	//smallest ethernet frame accounted by the agent
+	minFrameLength = 64
+)
+
+//GenerateRecords emits count records sharing the protocols, ports and
+//durations of the legacy flows. The ips override the legacy addresses.
+func GenerateRecords(r *rand.Rand, ips []string, count int) []*pbflow.Record {
+	ipv6 := len(ips) > 0 && net.ParseIP(ips[0]).To4() == nil
+	legacyIPs := ips
+	if ipv6 {
+		legacyIPs = nil
+	}
+
 	records := []*pbflow.Record{}
+	for len(records) < count {
+		//legacy packets hold 16 records
+		for _, payload := range legacy.GenerateNetflow(r, 16, legacyIPs, false).Records {
+			if len(records) == count {
+				break
+			}
+			src := uint32ToIP(payload.SrcIP)
+			dst := uint32ToIP(payload.DstIP)
+			if ipv6 {
+				src = net.ParseIP(ips[r.Int()%len(ips)])
+				dst = net.ParseIP(ips[r.Int()%len(ips)])
+			}
+			records = append(records, createRecord(r, payload, src, dst))
+		}
+	}
+	return records
+}
 
-	t := time.Now()
-	var srcIp, dstIp net.IP
-	if len(ips) > 0 {
-		srcIp = net.ParseIP(ips[rand.Int()%len(ips)]).To4()
-		dstIp = net.ParseIP(ips[rand.Int()%len(ips)]).To4()
-	} else {
-		srcIp = net.ParseIP("10.10.29.7").To4()
-		dstIp = net.ParseIP("10.10.29.8").To4()
+//GenerateScenarioRecords emits count records drawn from a scenario
+func GenerateScenarioRecords(r *rand.Rand, s *scenario.Scenario, ips []string, count int) []*pbflow.Record {
+	records := []*pbflow.Record{}
+	for i := 0; i < count; i++ {
+		f := s.Next(r, ips)
+		records = append(records, createRecord(r, legacy.CreateScenarioFlow(r, f), f.SrcIP, f.DstIP))
 	}
+	return records
+}
 
-	flow := pbflow.Record{
-		EthProtocol: rand.Uint32(),
-		Direction:   directions[rand.Int()%len(directions)],
-		TimeFlowStart: &timestamppb.Timestamp{
-			Seconds: t.Unix(),
-			Nanos:   0,
-		},
-		TimeFlowEnd: &timestamppb.Timestamp{
-			Seconds: t.Unix(),
-			Nanos:   0,
-		},
+//createRecord converts a legacy record to an agent record ending now
+func createRecord(r *rand.Rand, payload legacy.NetflowPayload, src, dst net.IP) *pbflow.Record {
+	ethProtocol := uint32(ETH_P_IP)
+	if src.To4() == nil {
+		ethProtocol = ETH_P_IPV6
+	}
+
+	//only tcp and udp flows have ports
+	var srcPort, dstPort uint32
+	if payload.IpProtocol == 6 || payload.IpProtocol == 17 {
+		srcPort, dstPort = uint32(payload.SrcPort), uint32(payload.DstPort)
+	}
+
+	packets := uint64(payload.NumPackets)
+	if packets == 0 {
+		packets = 1
+	}
+	bytes := uint64(payload.NumOctets)
+	if bytes < packets*minFrameLength {
+		bytes = packets * minFrameLength
+	}
+
+	end := clock.Now()
+	start := end.Add(-time.Duration(payload.SysUptimeEnd-payload.SysUptimeStart) * time.Millisecond)
+
+	return &pbflow.Record{
+		EthProtocol:   ethProtocol,
+		Direction:     directions[r.Int()%len(directions)],
+		TimeFlowStart: timestamppb.New(start),
+		TimeFlowEnd:   timestamppb.New(end),
 		DataLink: &pbflow.DataLink{
-			SrcMac: rand.Uint64(),
-			DstMac: rand.Uint64(),
+			SrcMac: r.Uint64() & 0xffffffffffff,
+			DstMac: r.Uint64() & 0xffffffffffff,
 		},
 		Network: &pbflow.Network{
-			SrcAddr: &pbflow.IP{
-				IpFamily: &pbflow.IP_Ipv4{
-					Ipv4: ip2Long(srcIp),
-				},
-			},
-			DstAddr: &pbflow.IP{
-				IpFamily: &pbflow.IP_Ipv4{
-					Ipv4: ip2Long(dstIp),
-				},
-			},
+			SrcAddr: toPbIP(src),
+			DstAddr: toPbIP(dst),
 		},
 		Transport: &pbflow.Transport{
-			SrcPort:  uint32(rand.Int() % 9999),
-			DstPort:  uint32(rand.Int() % 9999),
-			Protocol: uint32(rand.Int() % 255),
+			SrcPort:  srcPort,
+			DstPort:  dstPort,
+			Protocol: uint32(payload.IpProtocol),
 		},
-		Bytes:     uint64(getRandInt(1, 9000)),
-		Packets:   1,
-		Interface: "fake nflow-generator record",
+		Bytes:     bytes,
+		Packets:   packets,
+		Interface: interfaces[r.Int()%len(interfaces)],
 	}
-	records = append(records, &flow)
+}
 
-	return records
+//FlowKey returns the 5-tuple of a record: addresses, ports and protocol
+func FlowKey(record *pbflow.Record) []byte {
+	key := new(bytes.Buffer)
+	for _, ip := range []*pbflow.IP{record.GetNetwork().GetSrcAddr(), record.GetNetwork().GetDstAddr()} {
+		if v6 := ip.GetIpv6(); v6 != nil {
+			key.Write(v6)
+		} else {
+			binary.Write(key, binary.BigEndian, ip.GetIpv4())
+		}
+	}
+	binary.Write(key, binary.BigEndian, record.GetTransport().GetSrcPort())
+	binary.Write(key, binary.BigEndian, record.GetTransport().GetDstPort())
+	binary.Write(key, binary.BigEndian, record.GetTransport().GetProtocol())
+	return key.Bytes()
+}
+
+func toPbIP(ip net.IP) *pbflow.IP {
+	if ip.To4() == nil {
+		return &pbflow.IP{
+			IpFamily: &pbflow.IP_Ipv6{
+				Ipv6: ip.To16(),
+			},
+		}
+	}
+	return &pbflow.IP{
+		IpFamily: &pbflow.IP_Ipv4{
+			Ipv4: ip2Long(ip.To4()),
+		},
+	}
 }
 
 func ip2Long(ip net.IP) uint32 {
@@ -75,6 +156,43 @@ func ip2Long(ip net.IP) uint32 {
 	return long
 }
 
-func getRandInt(min, max int) int {
-	return rand.Intn(max-min+1) + min
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+//RecordMap returns the fields of a record named like the json flows of
+//the eBPF agent and flowlogs-pipeline
+func RecordMap(record *pbflow.Record) map[string]interface{} {
+	return map[string]interface{}{
+		"Etype":           record.EthProtocol,
+		"FlowDirection":   int(record.Direction),
+		"TimeFlowStartMs": record.GetTimeFlowStart().AsTime().UnixNano() / int64(time.Millisecond),
+		"TimeFlowEndMs":   record.GetTimeFlowEnd().AsTime().UnixNano() / int64(time.Millisecond),
+		"SrcMac":          macString(record.GetDataLink().GetSrcMac()),
+		"DstMac":          macString(record.GetDataLink().GetDstMac()),
+		"SrcAddr":         IPString(record.GetNetwork().GetSrcAddr()),
+		"DstAddr":         IPString(record.GetNetwork().GetDstAddr()),
+		"SrcPort":         record.GetTransport().GetSrcPort(),
+		"DstPort":         record.GetTransport().GetDstPort(),
+		"Proto":           record.GetTransport().GetProtocol(),
+		"Bytes":           record.Bytes,
+		"Packets":         record.Packets,
+		"Interface":       record.Interface,
+	}
+}
+
+//IPString formats an address of a record
+func IPString(ip *pbflow.IP) string {
+	if v6 := ip.GetIpv6(); v6 != nil {
+		return net.IP(v6).String()
+	}
+	return uint32ToIP(ip.GetIpv4()).String()
+}
+
+func macString(mac uint64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, mac)
+	return net.HardwareAddr(b[2:]).String()
 }
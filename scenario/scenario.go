@@ -0,0 +1,427 @@
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//Scenario describes the traffic mix as weighted flow profiles
+type Scenario struct {
+	Profiles []Profile `yaml:"profiles" json:"profiles"`
+	profiles []profile
+	weights  int
+}
+
+//Profile describes a family of flows
+type Profile struct {
+	Name     string       `yaml:"name" json:"name"`
+	Protocol string       `yaml:"protocol" json:"protocol"` // tcp, udp, icmp or ip protocol number
+	SrcPorts string       `yaml:"srcPorts" json:"srcPorts"` // comma separated ports or ranges, e.g. "80,8000-8080". Default: 1024-65535
+	DstPorts string       `yaml:"dstPorts" json:"dstPorts"` // comma separated ports or ranges. Default: 1024-65535
+	SrcCIDRs []string     `yaml:"srcCIDRs" json:"srcCIDRs"` // source networks, specified ips are used if empty
+	DstCIDRs []string     `yaml:"dstCIDRs" json:"dstCIDRs"` // destination networks, specified ips are used if empty
+	Packets  Distribution `yaml:"packets" json:"packets"`
+	Bytes    Distribution `yaml:"bytes" json:"bytes"`
+	Weight   int          `yaml:"weight" json:"weight"`     // relative weight in the traffic mix, 0 excludes the profile
+	TCPFlags []string     `yaml:"tcpFlags" json:"tcpFlags"` // FIN, SYN, RST, PSH, ACK, URG, ECE, CWR
+	ToS      uint8        `yaml:"tos" json:"tos"`
+}
+
+//Distribution of a flow counter
+type Distribution struct {
+	Type   string  `yaml:"type" json:"type"` // constant, uniform, normal or exponential. Default: uniform
+	Value  float64 `yaml:"value" json:"value"`
+	Min    float64 `yaml:"min" json:"min"`
+	Max    float64 `yaml:"max" json:"max"`
+	Mean   float64 `yaml:"mean" json:"mean"`
+	StdDev float64 `yaml:"stddev" json:"stddev"`
+}
+
+//Flow is a generated flow, shared by every exporter type
+type Flow struct {
+	Profile   string
+	SrcIP     net.IP
+	DstIP     net.IP
+	SrcPort   uint16
+	DstPort   uint16
+	Protocol  uint8
+	TCPFlags  uint8
+	ToS       uint8
+	Packets   uint64
+	Bytes     uint64
+	IPVersion int
+}
+
+type portRange struct {
+	min, max int
+}
+
+//compiled profile
+type profile struct {
+	Profile
+	protocol  uint8
+	srcPorts  []portRange
+	dstPorts  []portRange
+	srcNets   []*net.IPNet
+	dstNets   []*net.IPNet
+	tcpFlags  uint8
+	ipVersion int // 0 when the profile takes the specified ips
+}
+
+var protocols = map[string]uint8{
+	"icmp":   1,
+	"tcp":    6,
+	"udp":    17,
+	"icmpv6": 58,
+	"sctp":   132,
+}
+
+var tcpFlags = map[string]uint8{
+	"FIN": 0x01,
+	"SYN": 0x02,
+	"RST": 0x04,
+	"PSH": 0x08,
+	"ACK": 0x10,
+	"URG": 0x20,
+	"ECE": 0x40,
+	"CWR": 0x80,
+}
+
+var (
+	defaultPorts   = []portRange{{1024, 65535}}
+	defaultPackets = Distribution{Type: "uniform", Min: 1, Max: 1024}
+	defaultBytes   = Distribution{Type: "uniform", Min: 64, Max: 1048576}
+	defaultNets    = map[int]string{
+		4: "10.0.0.0/8",
+		6: "2001:db8::/32",
+	}
+)
+
+//Load reads a scenario from a json file, or a yaml file for any other extension
+func Load(path string) (*Scenario, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scenario{}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		//like yaml.UnmarshalStrict, unknown keys are errors
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(s)
+	} else {
+		err = yaml.UnmarshalStrict(content, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't parse scenario %s: %v", path, err)
+	}
+
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *Scenario) compile() error {
+	if len(s.Profiles) == 0 {
+		return fmt.Errorf("no profile defined")
+	}
+
+	s.profiles = nil
+	s.weights = 0
+	for i, p := range s.Profiles {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("profile-%d", i)
+		}
+		c, err := compileProfile(p)
+		if err != nil {
+			return fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		s.profiles = append(s.profiles, c)
+		s.weights += c.Weight
+	}
+	if s.weights == 0 {
+		return fmt.Errorf("at least one weight must be positive")
+	}
+	return nil
+}
+
+func compileProfile(p Profile) (profile, error) {
+	var err error
+	c := profile{Profile: p}
+
+	if c.Weight < 0 {
+		return c, fmt.Errorf("negative weight %d", c.Weight)
+	}
+
+	if c.protocol, err = parseProtocol(p.Protocol); err != nil {
+		return c, err
+	}
+	if c.srcPorts, err = parsePorts(p.SrcPorts); err != nil {
+		return c, err
+	}
+	if c.dstPorts, err = parsePorts(p.DstPorts); err != nil {
+		return c, err
+	}
+	if c.srcNets, err = parseCIDRs(p.SrcCIDRs); err != nil {
+		return c, err
+	}
+	if c.dstNets, err = parseCIDRs(p.DstCIDRs); err != nil {
+		return c, err
+	}
+
+	for _, nets := range [][]*net.IPNet{c.srcNets, c.dstNets} {
+		for _, n := range nets {
+			version := ipVersion(n.IP)
+			if c.ipVersion != 0 && c.ipVersion != version {
+				return c, fmt.Errorf("mixed ipv4 and ipv6 networks")
+			}
+			c.ipVersion = version
+		}
+	}
+	if (len(c.srcNets) == 0) != (len(c.dstNets) == 0) {
+		return c, fmt.Errorf("srcCIDRs and dstCIDRs must be both set or both empty")
+	}
+
+	for _, name := range p.TCPFlags {
+		flag, found := tcpFlags[strings.ToUpper(name)]
+		if !found {
+			return c, fmt.Errorf("unknown tcp flag %s", name)
+		}
+		c.tcpFlags |= flag
+	}
+
+	if c.Packets == (Distribution{}) {
+		c.Packets = defaultPackets
+	}
+	if c.Bytes == (Distribution{}) {
+		c.Bytes = defaultBytes
+	}
+	for _, d := range []Distribution{c.Packets, c.Bytes} {
+		if err := d.validate(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func parseProtocol(s string) (uint8, error) {
+	if s == "" {
+		return protocols["tcp"], nil
+	}
+	if p, found := protocols[strings.ToLower(s)]; found {
+		return p, nil
+	}
+	p, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown protocol %s", s)
+	}
+	return uint8(p), nil
+}
+
+func parsePorts(s string) ([]portRange, error) {
+	if s == "" {
+		return defaultPorts, nil
+	}
+	var ranges []portRange
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		min, err := strconv.ParseUint(bounds[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", part)
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = strconv.ParseUint(bounds[1], 10, 16); err != nil || max < min {
+				return nil, fmt.Errorf("invalid port range %s", part)
+			}
+		}
+		ranges = append(ranges, portRange{int(min), int(max)})
+	}
+	return ranges, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (d Distribution) validate() error {
+	switch d.Type {
+	case "constant", "uniform", "normal", "exponential":
+	case "":
+	default:
+		return fmt.Errorf("unknown distribution %s", d.Type)
+	}
+	if d.Max != 0 && d.Max < d.Min {
+		return fmt.Errorf("distribution max %v is lower than min %v", d.Max, d.Min)
+	}
+	return nil
+}
+
+//Supports tells if the scenario can generate flows of the ip version
+func (s *Scenario) Supports(version int) bool {
+	for _, p := range s.profiles {
+		if p.Weight > 0 && (p.ipVersion == 0 || p.ipVersion == version) {
+			return true
+		}
+	}
+	return false
+}
+
+//Next draws a flow from the weighted profiles matching the ip version of ips.
+//Profiles without networks pick their addresses from ips.
+func (s *Scenario) Next(r *rand.Rand, ips []string) Flow {
+	version := 4
+	if len(ips) > 0 && net.ParseIP(ips[0]).To4() == nil {
+		version = 6
+	}
+
+	p := s.pick(r, version)
+	f := Flow{
+		Profile:   p.Name,
+		Protocol:  p.protocol,
+		ToS:       p.ToS,
+		IPVersion: version,
+	}
+
+	switch {
+	case len(p.srcNets) > 0:
+		f.SrcIP = randomIP(r, p.srcNets[r.Intn(len(p.srcNets))])
+		f.DstIP = randomIP(r, p.dstNets[r.Intn(len(p.dstNets))])
+		f.IPVersion = p.ipVersion
+	case len(ips) > 0:
+		f.SrcIP = net.ParseIP(ips[r.Intn(len(ips))])
+		f.DstIP = net.ParseIP(ips[r.Intn(len(ips))])
+	default:
+		_, n, _ := net.ParseCIDR(defaultNets[version])
+		f.SrcIP = randomIP(r, n)
+		f.DstIP = randomIP(r, n)
+	}
+	if f.IPVersion == 4 {
+		f.SrcIP = f.SrcIP.To4()
+		f.DstIP = f.DstIP.To4()
+	}
+
+	//icmp and icmpv6 have no ports
+	if f.Protocol != 1 && f.Protocol != 58 {
+		f.SrcPort = randomPort(r, p.srcPorts)
+		f.DstPort = randomPort(r, p.dstPorts)
+	}
+	if f.Protocol == 6 {
+		f.TCPFlags = p.tcpFlags
+	}
+
+	f.Packets = uint64(p.Packets.sample(r, 1))
+	f.Bytes = uint64(p.Bytes.sample(r, float64(f.Packets)))
+	return f
+}
+
+//weighted pick among the profiles supporting the ip version
+func (s *Scenario) pick(r *rand.Rand, version int) profile {
+	total := 0
+	for _, p := range s.profiles {
+		if p.ipVersion == 0 || p.ipVersion == version {
+			total += p.Weight
+		}
+	}
+	if total == 0 {
+		//no weighted profile of that version, fall back to any weighted profile
+		return s.pickAny(r)
+	}
+
+	n := r.Intn(total)
+	for _, p := range s.profiles {
+		if p.ipVersion != 0 && p.ipVersion != version {
+			continue
+		}
+		if n < p.Weight {
+			return p
+		}
+		n -= p.Weight
+	}
+	return s.profiles[len(s.profiles)-1]
+}
+
+//weighted pick among all the profiles
+func (s *Scenario) pickAny(r *rand.Rand) profile {
+	n := r.Intn(s.weights)
+	for _, p := range s.profiles {
+		if n < p.Weight {
+			return p
+		}
+		n -= p.Weight
+	}
+	return s.profiles[len(s.profiles)-1]
+}
+
+//sample a value of the distribution, never lower than floor
+func (d Distribution) sample(r *rand.Rand, floor float64) float64 {
+	var v float64
+	switch d.Type {
+	case "constant":
+		v = d.Value
+	case "normal":
+		v = r.NormFloat64()*d.StdDev + d.Mean
+	case "exponential":
+		v = r.ExpFloat64() * d.Mean
+	default:
+		v = d.Min + r.Float64()*(d.Max-d.Min)
+	}
+
+	if d.Max > 0 && v > d.Max {
+		v = d.Max
+	}
+	if v < d.Min {
+		v = d.Min
+	}
+	if v < floor {
+		v = floor
+	}
+	return v
+}
+
+func randomPort(r *rand.Rand, ranges []portRange) uint16 {
+	pr := ranges[r.Intn(len(ranges))]
+	return uint16(pr.min + r.Intn(pr.max-pr.min+1))
+}
+
+//random address of the network
+func randomIP(r *rand.Rand, n *net.IPNet) net.IP {
+	ip := make(net.IP, len(n.IP))
+	for i := range ip {
+		ip[i] = n.IP[i] | (byte(r.Intn(256)) &^ n.Mask[i])
+	}
+	return ip
+}
+
+func ipVersion(ip net.IP) int {
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
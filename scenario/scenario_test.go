@@ -0,0 +1,67 @@
+package scenario
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCompileWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		err     bool
+	}{
+		{"weighted", []int{3, 1}, false},
+		{"zero excludes a profile", []int{0, 1}, false},
+		{"negative", []int{-1, 1}, true},
+		{"all zero", []int{0, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scenario{}
+			for _, w := range tt.weights {
+				s.Profiles = append(s.Profiles, Profile{Protocol: "tcp", Weight: w})
+			}
+			if err := s.compile(); (err != nil) != tt.err {
+				t.Errorf("got error %v, want an error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNextWeights(t *testing.T) {
+	s := &Scenario{Profiles: []Profile{
+		{Name: "excluded", Protocol: "udp", Weight: 0},
+		{Name: "rare", Protocol: "tcp", Weight: 1},
+		{Name: "frequent", Protocol: "tcp", Weight: 3},
+		{Name: "ipv6 only", Protocol: "tcp", SrcCIDRs: []string{"2001:db8::/64"}, DstCIDRs: []string{"2001:db8::/64"}, Weight: 4},
+	}}
+	if err := s.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		counts[s.Next(r, []string{"10.0.0.1"}).Profile]++
+	}
+	if counts["excluded"] != 0 || counts["ipv6 only"] != 0 {
+		t.Errorf("drew %v, want neither excluded nor ipv6 only profiles", counts)
+	}
+	if counts["frequent"] < 2*counts["rare"] {
+		t.Errorf("drew %v, want about 3 frequent profiles for a rare one", counts)
+	}
+}
+
+func TestSupportsIgnoresExcludedProfiles(t *testing.T) {
+	s := &Scenario{Profiles: []Profile{
+		{Protocol: "tcp", SrcCIDRs: []string{"10.0.0.0/8"}, DstCIDRs: []string{"10.0.0.0/8"}, Weight: 1},
+		{Protocol: "tcp", SrcCIDRs: []string{"2001:db8::/64"}, DstCIDRs: []string{"2001:db8::/64"}, Weight: 0},
+	}}
+	if err := s.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Supports(4) || s.Supports(6) {
+		t.Errorf("supports ipv4 %v and ipv6 %v, want only ipv4", s.Supports(4), s.Supports(6))
+	}
+}
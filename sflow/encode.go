@@ -0,0 +1,108 @@
+package sflow
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+//Encode a Datagram to a sFlow v5 packet byte array.
+func Encode(d Datagram, seqNo uint32) []byte {
+	fillHeaders(&d)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, d.Header.Version)
+	if ip := d.Header.AgentAddress.To4(); ip != nil {
+		binary.Write(buf, binary.BigEndian, ADDRESS_IPV4)
+		buf.Write(ip)
+	} else {
+		binary.Write(buf, binary.BigEndian, ADDRESS_IPV6)
+		buf.Write(d.Header.AgentAddress.To16())
+	}
+	binary.Write(buf, binary.BigEndian, d.Header.SubAgentID)
+	binary.Write(buf, binary.BigEndian, seqNo)
+	binary.Write(buf, binary.BigEndian, d.Header.Uptime)
+	binary.Write(buf, binary.BigEndian, d.Header.NumSamples)
+
+	for _, sample := range d.CounterSamples {
+		writeCounterSample(buf, sample)
+	}
+	for _, sample := range d.FlowSamples {
+		writeFlowSample(buf, sample)
+	}
+
+	return buf.Bytes()
+}
+
+func writeFlowSample(buf *bytes.Buffer, sample FlowSample) {
+	binary.Write(buf, binary.BigEndian, sample.Header)
+	binary.Write(buf, binary.BigEndian, sample.SequenceNo)
+	binary.Write(buf, binary.BigEndian, sample.SourceID)
+	binary.Write(buf, binary.BigEndian, sample.SamplingRate)
+	binary.Write(buf, binary.BigEndian, sample.SamplePool)
+	binary.Write(buf, binary.BigEndian, sample.Drops)
+	binary.Write(buf, binary.BigEndian, sample.Input)
+	binary.Write(buf, binary.BigEndian, sample.Output)
+	binary.Write(buf, binary.BigEndian, uint32(len(sample.Records)))
+
+	for _, record := range sample.Records {
+		binary.Write(buf, binary.BigEndian, record.Header)
+		binary.Write(buf, binary.BigEndian, record.Protocol)
+		binary.Write(buf, binary.BigEndian, record.FrameLength)
+		binary.Write(buf, binary.BigEndian, record.Stripped)
+		binary.Write(buf, binary.BigEndian, uint32(len(record.Bytes)))
+		buf.Write(record.Bytes)
+		for i := 0; i < record.padding; i++ {
+			binary.Write(buf, binary.BigEndian, PADDING)
+		}
+	}
+}
+
+func writeCounterSample(buf *bytes.Buffer, sample CounterSample) {
+	binary.Write(buf, binary.BigEndian, sample.Header)
+	binary.Write(buf, binary.BigEndian, sample.SequenceNo)
+	binary.Write(buf, binary.BigEndian, sample.SourceID)
+	binary.Write(buf, binary.BigEndian, uint32(len(sample.Records)))
+
+	for _, record := range sample.Records {
+		binary.Write(buf, binary.BigEndian, record)
+	}
+}
+
+//fill every head in datagram, including sample count, lengths and padding.
+func fillHeaders(d *Datagram) {
+	d.Header.NumSamples = uint32(len(d.FlowSamples) + len(d.CounterSamples))
+
+	for i := range d.FlowSamples {
+		fillFlowSample(&(d.FlowSamples[i]))
+	}
+	for i := range d.CounterSamples {
+		fillCounterSample(&(d.CounterSamples[i]))
+	}
+}
+
+func fillFlowSample(sample *FlowSample) {
+	length := uint32(32) //flow sample fields and record count
+
+	for i := range sample.Records {
+		record := &(sample.Records[i])
+		recordLength := uint32(16 + len(record.Bytes)) //raw header fields and header length
+		record.padding = 0
+		if recordLength%4 != 0 {
+			record.padding = int(4 - recordLength%4)
+			recordLength += 4 - recordLength%4
+		}
+		record.Header = RecordHeader{Format: RAW_PACKET_HEADER, Length: recordLength}
+		length += 8 + recordLength
+	}
+	sample.Header = RecordHeader{Format: FLOW_SAMPLE, Length: length}
+}
+
+func fillCounterSample(sample *CounterSample) {
+	length := uint32(12) //counter sample fields and record count
+
+	for i := range sample.Records {
+		sample.Records[i].Header = RecordHeader{Format: GENERIC_INTERFACE_COUNTERS, Length: 88}
+		length += 8 + 88
+	}
+	sample.Header = RecordHeader{Format: COUNTERS_SAMPLE, Length: length}
+}
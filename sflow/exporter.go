@@ -0,0 +1,225 @@
+package sflow
+
+import (
+	"math/rand"
+	"net"
+	"nflow-generator/clock"
+	"nflow-generator/legacy"
+	"nflow-generator/scenario"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultSamplingRate    = 1000
+	DefaultCounterInterval = 20 * time.Second
+	DefaultMTU             = 1400
+)
+
+//interfaces of the simulated switch, flows of records without interface
+//indexes are accounted as received on the first one and sent on the second
+var interfaceIndexes = []uint32{1, 2}
+
+//DefaultAgentAddress is the address of the simulated sampling agent
+var DefaultAgentAddress = net.ParseIP("10.10.29.1").To4()
+
+//Exporter keeps the sampling agent state: sequence numbers, sample pools
+//and interface counters
+type Exporter struct {
+	AgentAddress    net.IP
+	SubAgentID      uint32
+	SamplingRate    uint32             // one packet sampled out of SamplingRate
+	CounterInterval time.Duration      // interval between counter samples, 0 to disable
+	MTU             int                // maximum datagram size used to batch flow samples
+	Scenario        *scenario.Scenario // draw flows from this scenario when set
+	start           time.Time
+	seqNum          uint32
+	flowSeqNums     map[uint32]uint32 // by source id
+	samplePools     map[uint32]uint32 // by source id
+	counterSeqNums  map[uint32]uint32 // by source id
+	partSeqNums     map[uint32]uint32 // by source id, of the adopted flow samples
+	lastCounters    time.Time
+	interfaces      map[uint32]*InterfaceCounters
+	records         []legacy.NetflowPayload // legacy records left to sample
+	recordsIPv6     bool                    // records were generated for ipv6 ips
+}
+
+func NewExporter(samplingRate uint32, counterInterval time.Duration, mtu int) *Exporter {
+	if samplingRate == 0 {
+		samplingRate = DefaultSamplingRate
+	}
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	e := &Exporter{
+		AgentAddress:    DefaultAgentAddress,
+		SamplingRate:    samplingRate,
+		CounterInterval: counterInterval,
+		MTU:             mtu,
+		start:           clock.Now(),
+		flowSeqNums:     map[uint32]uint32{},
+		samplePools:     map[uint32]uint32{},
+		counterSeqNums:  map[uint32]uint32{},
+		partSeqNums:     map[uint32]uint32{},
+		interfaces:      map[uint32]*InterfaceCounters{},
+	}
+	for _, index := range interfaceIndexes {
+		e.interfaces[index] = &InterfaceCounters{
+			IfIndex:     index,
+			IfType:      6,           //ethernetCsmacd
+			IfSpeed:     10000000000, //10Gbps
+			IfDirection: 1,
+			IfStatus:    3,
+		}
+	}
+	return e
+}
+
+//GenerateDatagram builds the next datagram of the agent, packing as many
+//flow samples as fit in the exporter MTU. Counter samples of the
+//interfaces are embedded every CounterInterval.
+func (e *Exporter) GenerateDatagram(r *rand.Rand, ips []string, fi bool) *Datagram {
+	now := clock.Now()
+	d := &Datagram{
+		Header: DatagramHeader{
+			Version:      VERSION,
+			AgentAddress: e.AgentAddress,
+			SubAgentID:   e.SubAgentID,
+			Uptime:       uint32(now.Sub(e.start) / time.Millisecond),
+		},
+	}
+
+	size := e.headerSize()
+	if e.lastCounters.IsZero() || (e.CounterInterval > 0 && now.Sub(e.lastCounters) >= e.CounterInterval) {
+		d.CounterSamples = e.counterSamples()
+		e.lastCounters = now
+		size += len(d.CounterSamples) * counterSampleSize
+	}
+
+	count := (e.MTU - size) / maxFlowSampleSize
+	if count < 1 {
+		count = 1
+	}
+
+	ipv6 := isIPv6(ips)
+	for i := 0; i < count; i++ {
+		var record legacy.NetflowPayload
+		var src, dst net.IP
+		if e.Scenario != nil {
+			f := e.Scenario.Next(r, ips)
+			record = legacy.CreateScenarioFlow(r, f)
+			legacy.SetFalseIndex(&record, fi)
+			src, dst = f.SrcIP, f.DstIP
+		} else {
+			record = e.nextRecord(r, ips, fi)
+			if ipv6 {
+				src = net.ParseIP(ips[r.Int()%len(ips)])
+				dst = net.ParseIP(ips[r.Int()%len(ips)])
+			}
+		}
+		d.FlowSamples = append(d.FlowSamples, e.sample(r, record, src, dst))
+	}
+
+	return d
+}
+
+//sizes of an encoded counter sample of an interface, and of the largest flow
+//sample: headers, ethernet, ipv6 and tcp headers
+const (
+	counterSampleSize = 8 + 12 + 8 + 88
+	maxFlowSampleSize = 8 + 32 + 8 + 16 + ETHERNET_HEADER_LENGTH + IPV6_HEADER_LENGTH + TCP_HEADER_LENGTH + 2
+)
+
+//size of the datagram header, its agent address is ipv4 or ipv6
+func (e *Exporter) headerSize() int {
+	if e.AgentAddress.To4() == nil {
+		return 28 + 12
+	}
+	return 28
+}
+
+//MinMTU returns the size of the largest datagram holding a single flow
+//sample, sent with the counter samples of the interfaces
+func (e *Exporter) MinMTU() int {
+	return e.headerSize() + len(e.interfaces)*counterSampleSize + maxFlowSampleSize
+}
+
+//next legacy record, generated by packets of 16 records like netflow v5
+func (e *Exporter) nextRecord(r *rand.Rand, ips []string, fi bool) legacy.NetflowPayload {
+	ipv6 := isIPv6(ips)
+	if len(e.records) == 0 || e.recordsIPv6 != ipv6 {
+		legacyIPs := ips
+		if ipv6 {
+			legacyIPs = nil
+		}
+		e.records = legacy.GenerateNetflow(r, 16, legacyIPs, fi).Records
+		e.recordsIPv6 = ipv6
+	}
+	record := e.records[0]
+	e.records = e.records[1:]
+	return record
+}
+
+//sample a packet of a record and account its flow in the interface counters
+func (e *Exporter) sample(r *rand.Rand, record legacy.NetflowPayload, src, dst net.IP) FlowSample {
+	sample := CreateFlowSample(r, record, src, dst, e.SamplingRate)
+
+	e.flowSeqNums[sample.SourceID]++
+	sample.SequenceNo = e.flowSeqNums[sample.SourceID]
+	e.samplePools[sample.SourceID] += e.SamplingRate
+	sample.SamplePool = e.samplePools[sample.SourceID]
+
+	//the sample stands for SamplingRate packets of the sampled frame length,
+	//as accounted by the sample pool
+	packets := e.SamplingRate
+	octets := uint64(e.SamplingRate) * uint64(sample.Records[0].FrameLength)
+	if in, found := e.interfaces[sourceIndex(sample.Input, interfaceIndexes[0])]; found {
+		in.InOctets += octets
+		in.InUcastPkts += packets
+	}
+	if out, found := e.interfaces[sourceIndex(sample.Output, interfaceIndexes[1])]; found {
+		out.OutOctets += octets
+		out.OutUcastPkts += packets
+	}
+	return sample
+}
+
+//a counter sample per interface of the agent
+func (e *Exporter) counterSamples() []CounterSample {
+	var indexes []uint32
+	for index := range e.interfaces {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	var samples []CounterSample
+	for _, index := range indexes {
+		e.counterSeqNums[index]++
+		samples = append(samples, CounterSample{
+			SequenceNo: e.counterSeqNums[index],
+			SourceID:   index,
+			Records:    []InterfaceCounters{*e.interfaces[index]},
+		})
+	}
+	return samples
+}
+
+//Adopt renumbers the flow samples of a part of a datagram generated by an
+//exporter of the same configuration, so that each collector of a hash
+//distribution gets gapless flow sample sequences
+func (e *Exporter) Adopt(d Datagram) Datagram {
+	samples := make([]FlowSample, len(d.FlowSamples))
+	for i, sample := range d.FlowSamples {
+		e.partSeqNums[sample.SourceID]++
+		sample.SequenceNo = e.partSeqNums[sample.SourceID]
+		samples[i] = sample
+	}
+	d.FlowSamples = samples
+	return d
+}
+
+//Encode a datagram of the exporter and update its sequence number
+func (e *Exporter) Encode(d Datagram) []byte {
+	e.seqNum++
+	return Encode(d, e.seqNum)
+}
@@ -0,0 +1,101 @@
+package sflow
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"nflow-generator/legacy"
+	"testing"
+	"time"
+)
+
+func TestSampleCounters(t *testing.T) {
+	e := NewExporter(100, time.Minute, 0)
+	record := legacy.NetflowPayload{
+		SrcIP:        0x0a000001,
+		DstIP:        0x0a000002,
+		SnmpInIndex:  1,
+		SnmpOutIndex: 2,
+		NumPackets:   5000,
+		NumOctets:    5000 * 500,
+		IpProtocol:   6,
+	}
+	r := rand.New(rand.NewSource(1))
+	var sample FlowSample
+	for i := 0; i < 3; i++ {
+		sample = e.sample(r, record, nil, nil)
+	}
+
+	// the counters account the packets the samples stand for, like the sample pool
+	in, out := e.interfaces[1], e.interfaces[2]
+	if in.InUcastPkts != sample.SamplePool || out.OutUcastPkts != sample.SamplePool {
+		t.Errorf("counted %d packets in and %d out, want the sample pool %d", in.InUcastPkts, out.OutUcastPkts, sample.SamplePool)
+	}
+	octets := uint64(sample.SamplePool) * uint64(sample.Records[0].FrameLength)
+	if in.InOctets != octets || out.OutOctets != octets {
+		t.Errorf("counted %d octets in and %d out, want %d", in.InOctets, out.OutOctets, octets)
+	}
+}
+
+func TestEncodeLengths(t *testing.T) {
+	tests := []struct {
+		name     string
+		agent    net.IP
+		src, dst net.IP
+		protocol uint8
+		header   int // length of the sampled headers
+	}{
+		{"ipv4 tcp", DefaultAgentAddress, nil, nil, 6, ETHERNET_HEADER_LENGTH + IPV4_HEADER_LENGTH + TCP_HEADER_LENGTH},
+		{"ipv4 udp", DefaultAgentAddress, nil, nil, 17, ETHERNET_HEADER_LENGTH + IPV4_HEADER_LENGTH + UDP_HEADER_LENGTH},
+		{"ipv6 tcp", net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3"), 6, ETHERNET_HEADER_LENGTH + IPV6_HEADER_LENGTH + TCP_HEADER_LENGTH},
+		{"ipv4 icmp", DefaultAgentAddress, nil, nil, 1, ETHERNET_HEADER_LENGTH + IPV4_HEADER_LENGTH + ICMP_HEADER_LENGTH},
+		{"ipv6 udp", net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3"), 17, ETHERNET_HEADER_LENGTH + IPV6_HEADER_LENGTH + UDP_HEADER_LENGTH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewExporter(100, time.Minute, 0)
+			e.AgentAddress = tt.agent
+			record := legacy.NetflowPayload{SrcIP: 0x0a000001, DstIP: 0x0a000002, NumPackets: 1, NumOctets: 1000, IpProtocol: tt.protocol}
+			d := Datagram{
+				Header:         DatagramHeader{Version: VERSION, AgentAddress: tt.agent},
+				CounterSamples: e.counterSamples()[:1],
+				FlowSamples:    []FlowSample{e.sample(rand.New(rand.NewSource(1)), record, tt.src, tt.dst)},
+			}
+			b := Encode(d, 1)
+
+			offset := e.headerSize()
+			if samples := binary.BigEndian.Uint32(b[offset-4:]); samples != 2 {
+				t.Fatalf("datagram of %d samples, want 2", samples)
+			}
+
+			//counter sample: header, fields, then a generic interface counters record
+			if format, length := binary.BigEndian.Uint32(b[offset:]), binary.BigEndian.Uint32(b[offset+4:]); format != COUNTERS_SAMPLE || length != counterSampleSize-8 {
+				t.Errorf("counter sample of format %d and length %d, want %d and %d", format, length, COUNTERS_SAMPLE, counterSampleSize-8)
+			}
+			if length := binary.BigEndian.Uint32(b[offset+24:]); length != 88 {
+				t.Errorf("interface counters record length %d, want 88", length)
+			}
+			offset += counterSampleSize
+
+			//flow sample: header, fields, then a raw packet header record padded to 4 bytes
+			padding := (4 - tt.header%4) % 4
+			recordLength := 16 + tt.header + padding
+			if format, length := binary.BigEndian.Uint32(b[offset:]), int(binary.BigEndian.Uint32(b[offset+4:])); format != FLOW_SAMPLE || length != 32+8+recordLength {
+				t.Errorf("flow sample of format %d and length %d, want %d and %d", format, length, FLOW_SAMPLE, 32+8+recordLength)
+			}
+			raw := b[offset+8+32:]
+			if format, length := binary.BigEndian.Uint32(raw), int(binary.BigEndian.Uint32(raw[4:])); format != RAW_PACKET_HEADER || length != recordLength {
+				t.Errorf("raw packet header of format %d and length %d, want %d and %d", format, length, RAW_PACKET_HEADER, recordLength)
+			}
+			if length := int(binary.BigEndian.Uint32(raw[20:])); length != tt.header {
+				t.Errorf("sampled %d header bytes, want %d", length, tt.header)
+			}
+			if want := offset + 8 + 32 + 8 + recordLength; len(b) != want {
+				t.Errorf("datagram of %d bytes, want %d", len(b), want)
+			}
+			if len(b)-offset > maxFlowSampleSize {
+				t.Errorf("flow sample of %d bytes, larger than %d", len(b)-offset, maxFlowSampleSize)
+			}
+		})
+	}
+}
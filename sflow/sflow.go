@@ -0,0 +1,127 @@
+package sflow
+
+import "net"
+
+//sFlow version 5 - https://sflow.org/sflow_version_5.txt
+const (
+	VERSION      = uint32(5)
+	ADDRESS_IPV4 = uint32(1)
+	ADDRESS_IPV6 = uint32(2)
+	PADDING      = uint8(0) // 1 byte
+
+	//sample formats, enterprise 0
+	FLOW_SAMPLE     = uint32(1)
+	COUNTERS_SAMPLE = uint32(2)
+
+	//flow record formats, enterprise 0
+	RAW_PACKET_HEADER = uint32(1)
+
+	//counter record formats, enterprise 0
+	GENERIC_INTERFACE_COUNTERS = uint32(1)
+
+	//header_protocol of raw packet headers
+	HEADER_PROTOCOL_ETHERNET = uint32(1)
+)
+
+type Datagram struct {
+	Header         DatagramHeader  `json:"header"`
+	FlowSamples    []FlowSample    `json:"flowSamples"`
+	CounterSamples []CounterSample `json:"counterSamples"`
+}
+
+//sFlow datagram header
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                           Version                             |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                     Agent Address Type                        |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                 Agent Address (4 or 16 bytes)                 |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                         Sub Agent ID                          |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                       Sequence Number                         |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                            Uptime                             |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                      Number of Samples                        |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type DatagramHeader struct {
+	Version      uint32 `json:"version"`
+	AgentAddress net.IP `json:"agent_address"` // IP address of the sampling agent
+	SubAgentID   uint32 `json:"sub_agent_id"`  // Distinguishes the datagram streams of an agent
+	SequenceNo   uint32 `json:"sequence_no"`   // Incremented with each datagram of the sub agent
+	Uptime       uint32 `json:"uptime"`        // Time in milliseconds since the agent was booted
+	NumSamples   uint32 `json:"num_samples"`
+}
+
+//sample and record header, the format is enterprise << 12 | format
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|         Enterprise                    |        Format         |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                           Length                              |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type RecordHeader struct {
+	Format uint32 `json:"format"`
+	Length uint32 `json:"length"` // Length of the data following the header
+}
+
+//flow sample, enterprise 0 format 1
+type FlowSample struct {
+	Header       RecordHeader      `json:"header"`
+	SequenceNo   uint32            `json:"sequence_no"`   // Incremented with each flow sample of the source
+	SourceID     uint32            `json:"source_id"`     // Source type << 24 | ifIndex of the sampling interface
+	SamplingRate uint32            `json:"sampling_rate"` // One packet sampled out of SamplingRate
+	SamplePool   uint32            `json:"sample_pool"`   // Total number of packets that could have been sampled
+	Drops        uint32            `json:"drops"`
+	Input        uint32            `json:"input"`  // ifIndex of the input interface, 0 if unknown
+	Output       uint32            `json:"output"` // ifIndex of the output interface, 0 if unknown
+	Records      []RawPacketHeader `json:"records"`
+}
+
+//raw packet header flow record, enterprise 0 format 1
+type RawPacketHeader struct {
+	Header      RecordHeader `json:"header"`
+	Protocol    uint32       `json:"protocol"`     // Protocol of the first header, 1 for ethernet
+	FrameLength uint32       `json:"frame_length"` // Length of the packet before sampling
+	Stripped    uint32       `json:"stripped"`     // Bytes removed from the packet, 4 for the ethernet FCS
+	Bytes       []byte       `json:"bytes"`        // Header bytes of the sampled packet
+	padding     int
+}
+
+//counters sample, enterprise 0 format 2
+type CounterSample struct {
+	Header     RecordHeader        `json:"header"`
+	SequenceNo uint32              `json:"sequence_no"` // Incremented with each counter sample of the source
+	SourceID   uint32              `json:"source_id"`   // Source type << 24 | ifIndex of the interface
+	Records    []InterfaceCounters `json:"records"`
+}
+
+//generic interface counters record, enterprise 0 format 1
+type InterfaceCounters struct {
+	Header           RecordHeader `json:"header"`
+	IfIndex          uint32       `json:"if_index"`
+	IfType           uint32       `json:"if_type"`
+	IfSpeed          uint64       `json:"if_speed"`
+	IfDirection      uint32       `json:"if_direction"` // 1 full duplex, 2 half duplex
+	IfStatus         uint32       `json:"if_status"`    // bit 0 admin up, bit 1 operational up
+	InOctets         uint64       `json:"in_octets"`
+	InUcastPkts      uint32       `json:"in_ucast_pkts"`
+	InMulticastPkts  uint32       `json:"in_multicast_pkts"`
+	InBroadcastPkts  uint32       `json:"in_broadcast_pkts"`
+	InDiscards       uint32       `json:"in_discards"`
+	InErrors         uint32       `json:"in_errors"`
+	InUnknownProtos  uint32       `json:"in_unknown_protos"`
+	OutOctets        uint64       `json:"out_octets"`
+	OutUcastPkts     uint32       `json:"out_ucast_pkts"`
+	OutMulticastPkts uint32       `json:"out_multicast_pkts"`
+	OutBroadcastPkts uint32       `json:"out_broadcast_pkts"`
+	OutDiscards      uint32       `json:"out_discards"`
+	OutErrors        uint32       `json:"out_errors"`
+	PromiscuousMode  uint32       `json:"promiscuous_mode"`
+}
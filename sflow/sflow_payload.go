@@ -0,0 +1,238 @@
+package sflow
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"nflow-generator/legacy"
+)
+
+const (
+	ETHERNET_HEADER_LENGTH = 14
+	ETHERNET_FCS_LENGTH    = 4
+	ETHERNET_MTU           = 1500
+	IPV4_HEADER_LENGTH     = 20
+	IPV6_HEADER_LENGTH     = 40
+	TCP_HEADER_LENGTH      = 20
+	UDP_HEADER_LENGTH      = 8
+	ICMP_HEADER_LENGTH     = 8
+)
+
+var srcMac = []byte{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01}
+var dstMac = []byte{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02}
+
+//CreateFlowSample builds a flow sample of a packet of the flow described by
+//a legacy record. src and dst override the ipv4 addresses of the record when set.
+func CreateFlowSample(r *rand.Rand, record legacy.NetflowPayload, src, dst net.IP, samplingRate uint32) FlowSample {
+	if src == nil || dst == nil {
+		src = make(net.IP, 4)
+		dst = make(net.IP, 4)
+		binary.BigEndian.PutUint32(src, record.SrcIP)
+		binary.BigEndian.PutUint32(dst, record.DstIP)
+	}
+
+	header := CreatePacketHeader(r, record, src, dst)
+	input, output := uint32(record.SnmpInIndex), uint32(record.SnmpOutIndex)
+	return FlowSample{
+		SourceID:     sourceIndex(input, 1),
+		SamplingRate: samplingRate,
+		Input:        input,
+		Output:       output,
+		Records: []RawPacketHeader{{
+			Protocol:    HEADER_PROTOCOL_ETHERNET,
+			FrameLength: uint32(ETHERNET_HEADER_LENGTH+packetLength(record, len(header)-ETHERNET_HEADER_LENGTH)) + ETHERNET_FCS_LENGTH,
+			Stripped:    ETHERNET_FCS_LENGTH,
+			Bytes:       header,
+		}},
+	}
+}
+
+//isIPv6 tells if the ips to draw flows from are ipv6 addresses
+func isIPv6(ips []string) bool {
+	return len(ips) > 0 && net.ParseIP(ips[0]).To4() == nil
+}
+
+//ifIndex of a flow sample source, falling back to a default interface
+//when the record does not know it
+func sourceIndex(ifIndex uint32, fallback uint32) uint32 {
+	if ifIndex == 0 {
+		return fallback
+	}
+	return ifIndex
+}
+
+//ip packet length of a sampled packet: the average packet size of the flow,
+//bounded by its headers and the ethernet mtu
+func packetLength(record legacy.NetflowPayload, headersLength int) int {
+	length := headersLength
+	if record.NumPackets > 0 {
+		length = int(record.NumOctets / record.NumPackets)
+	}
+	if length < headersLength {
+		length = headersLength
+	}
+	if length > ETHERNET_MTU {
+		length = ETHERNET_MTU
+	}
+	return length
+}
+
+//CreatePacketHeader synthesizes the ethernet, ip and transport headers of a
+//packet of the flow described by a legacy record
+func CreatePacketHeader(r *rand.Rand, record legacy.NetflowPayload, src, dst net.IP) []byte {
+	transport := transportHeader(r, record)
+
+	ipv6 := src.To4() == nil
+	ipHeaderLength := IPV4_HEADER_LENGTH
+	etherType := uint16(0x0800)
+	if ipv6 {
+		ipHeaderLength = IPV6_HEADER_LENGTH
+		etherType = 0x86dd
+	}
+	length := packetLength(record, ipHeaderLength+len(transport))
+
+	header := make([]byte, 0, ETHERNET_HEADER_LENGTH+ipHeaderLength+len(transport))
+	header = append(header, dstMac...)
+	header = append(header, srcMac...)
+	header = append(header, byte(etherType>>8), byte(etherType))
+
+	if ipv6 {
+		ip := make([]byte, IPV6_HEADER_LENGTH)
+		binary.BigEndian.PutUint32(ip[0:], 6<<28|uint32(record.IpTos)<<20)
+		binary.BigEndian.PutUint16(ip[4:], uint16(length-IPV6_HEADER_LENGTH))
+		ip[6] = record.IpProtocol
+		ip[7] = 64 //hop limit
+		copy(ip[8:], src.To16())
+		copy(ip[24:], dst.To16())
+		header = append(header, ip...)
+	} else {
+		ip := make([]byte, IPV4_HEADER_LENGTH)
+		ip[0] = 0x45 //version 4, 5 words header
+		ip[1] = record.IpTos
+		binary.BigEndian.PutUint16(ip[2:], uint16(length))
+		binary.BigEndian.PutUint16(ip[4:], uint16(r.Intn(0x10000)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) //don't fragment
+		ip[8] = 64                                 //ttl
+		ip[9] = record.IpProtocol
+		copy(ip[12:], src.To4())
+		copy(ip[16:], dst.To4())
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+		header = append(header, ip...)
+	}
+
+	if transport != nil {
+		if record.IpProtocol == 17 {
+			binary.BigEndian.PutUint16(transport[4:], uint16(length-ipHeaderLength))
+		}
+		header = append(header, transport...)
+	}
+	return header
+}
+
+//transport header of the record protocol, nil for other protocols
+func transportHeader(r *rand.Rand, record legacy.NetflowPayload) []byte {
+	switch record.IpProtocol {
+	case 6:
+		tcp := make([]byte, TCP_HEADER_LENGTH)
+		binary.BigEndian.PutUint16(tcp[0:], record.SrcPort)
+		binary.BigEndian.PutUint16(tcp[2:], record.DstPort)
+		binary.BigEndian.PutUint32(tcp[4:], r.Uint32()) //sequence number
+		binary.BigEndian.PutUint32(tcp[8:], r.Uint32()) //acknowledgment number
+		tcp[12] = 5 << 4                                //data offset
+		tcp[13] = record.TcpFlags
+		binary.BigEndian.PutUint16(tcp[14:], 65535) //window
+		return tcp
+	case 17:
+		udp := make([]byte, UDP_HEADER_LENGTH)
+		binary.BigEndian.PutUint16(udp[0:], record.SrcPort)
+		binary.BigEndian.PutUint16(udp[2:], record.DstPort)
+		return udp
+	case 1:
+		icmp := make([]byte, ICMP_HEADER_LENGTH)
+		icmp[0] = 8 //echo request
+		binary.BigEndian.PutUint16(icmp[4:], uint16(r.Intn(0x10000)))
+		binary.BigEndian.PutUint16(icmp[6:], uint16(r.Intn(0x10000)))
+		binary.BigEndian.PutUint16(icmp[2:], checksum(icmp))
+		return icmp
+	}
+	return nil
+}
+
+//internet checksum - RFC1071
+func checksum(b []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+//FlowKey returns the 5-tuple of the packet header of a flow sample
+func FlowKey(sample FlowSample) []byte {
+	if len(sample.Records) == 0 {
+		return nil
+	}
+	b := sample.Records[0].Bytes
+	if len(b) < ETHERNET_HEADER_LENGTH {
+		return nil
+	}
+	var key []byte
+	offset := ETHERNET_HEADER_LENGTH
+	var protocol byte
+	switch binary.BigEndian.Uint16(b[12:]) {
+	case 0x0800:
+		if len(b) < offset+IPV4_HEADER_LENGTH {
+			return nil
+		}
+		protocol = b[offset+9]
+		key = append(key, b[offset+12:offset+20]...)
+		offset += IPV4_HEADER_LENGTH
+	case 0x86dd:
+		if len(b) < offset+IPV6_HEADER_LENGTH {
+			return nil
+		}
+		protocol = b[offset+6]
+		key = append(key, b[offset+8:offset+40]...)
+		offset += IPV6_HEADER_LENGTH
+	}
+	key = append(key, protocol)
+	if (protocol == 6 || protocol == 17) && len(b) >= offset+4 {
+		key = append(key, b[offset:offset+4]...)
+	}
+	return key
+}
+
+//FlowStats returns the number of flow samples and the octets they
+//describe once scaled by the sampling rate
+func (d Datagram) FlowStats() (int, uint64) {
+	octets := uint64(0)
+	for _, sample := range d.FlowSamples {
+		for _, record := range sample.Records {
+			octets += uint64(record.FrameLength) * uint64(sample.SamplingRate)
+		}
+	}
+	return len(d.FlowSamples), octets
+}
+
+//Split distributes the flow samples of a datagram in parts datagrams,
+//counter samples are copied in every part
+func (d Datagram) Split(parts int, bucket func(sample FlowSample) int) []Datagram {
+	split := make([]Datagram, parts)
+	for i := range split {
+		split[i] = Datagram{
+			Header:         d.Header,
+			CounterSamples: d.CounterSamples,
+		}
+	}
+	for _, sample := range d.FlowSamples {
+		i := bucket(sample)
+		split[i].FlowSamples = append(split[i].FlowSamples, sample)
+	}
+	return split
+}
@@ -0,0 +1,140 @@
+package v9
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+//Encode a Message to a NetFlow v9 packet byte array.
+func Encode(msg Message, seqNo uint32) []byte {
+
+	if msg.Header.Count == 0 {
+		fillHeaders(&msg)
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, msg.Header.Version)
+	binary.Write(buf, binary.BigEndian, msg.Header.Count)
+	binary.Write(buf, binary.BigEndian, msg.Header.SysUptime)
+	binary.Write(buf, binary.BigEndian, msg.Header.UnixSecs)
+	binary.Write(buf, binary.BigEndian, seqNo)
+	binary.Write(buf, binary.BigEndian, msg.Header.SourceID)
+
+	for _, flowSet := range msg.TemplateFlowSet {
+		writeTemplateFlowSet(buf, flowSet)
+	}
+	for _, flowSet := range msg.OptionsTemplateFlowSet {
+		writeOptionsTemplateFlowSet(buf, flowSet)
+	}
+	writeDataFlowSet(buf, msg.DataFlowSet)
+
+	return buf.Bytes()
+}
+
+func writeTemplateFlowSet(buf *bytes.Buffer, flowSet TemplateFlowSet) {
+	binary.Write(buf, binary.BigEndian, flowSet.Header.ID)
+	binary.Write(buf, binary.BigEndian, flowSet.Header.Length)
+
+	for _, template := range flowSet.Templates {
+		binary.Write(buf, binary.BigEndian, template.ID)
+		binary.Write(buf, binary.BigEndian, template.FieldCount)
+		writeFields(buf, template.Fields)
+	}
+}
+
+func writeOptionsTemplateFlowSet(buf *bytes.Buffer, flowSet OptionsTemplateFlowSet) {
+	binary.Write(buf, binary.BigEndian, flowSet.Header.ID)
+	binary.Write(buf, binary.BigEndian, flowSet.Header.Length)
+
+	for _, template := range flowSet.OptionTemplates {
+		binary.Write(buf, binary.BigEndian, template.ID)
+		binary.Write(buf, binary.BigEndian, template.ScopeLength)
+		binary.Write(buf, binary.BigEndian, template.OptionLength)
+		writeFields(buf, template.ScopeFields)
+		writeFields(buf, template.OptionFields)
+	}
+	for i := 0; i < flowSet.padding; i++ {
+		binary.Write(buf, binary.BigEndian, PADDING)
+	}
+}
+
+func writeFields(buf *bytes.Buffer, fields []FieldSpecifier) {
+	for _, field := range fields {
+		binary.Write(buf, binary.BigEndian, field.Type)
+		binary.Write(buf, binary.BigEndian, field.Length)
+	}
+}
+
+func writeDataFlowSet(buf *bytes.Buffer, dataFlowSet []DataFlowSet) {
+	for _, flowSet := range dataFlowSet {
+		binary.Write(buf, binary.BigEndian, flowSet.Header.ID)
+		binary.Write(buf, binary.BigEndian, flowSet.Header.Length)
+		for _, record := range flowSet.Records {
+			for _, field := range record {
+				buf.Write(field.Value)
+			}
+		}
+		for i := 0; i < flowSet.padding; i++ {
+			binary.Write(buf, binary.BigEndian, PADDING)
+		}
+	}
+}
+
+//fill every head in message, including record count, lengths and padding.
+func fillHeaders(msg *Message) {
+	count := uint16(0)
+
+	for i := range msg.TemplateFlowSet {
+		fillTemplateFlowSet(&(msg.TemplateFlowSet[i]))
+		count += uint16(len(msg.TemplateFlowSet[i].Templates))
+	}
+	for i := range msg.OptionsTemplateFlowSet {
+		fillOptionsTemplateFlowSet(&(msg.OptionsTemplateFlowSet[i]))
+		count += uint16(len(msg.OptionsTemplateFlowSet[i].OptionTemplates))
+	}
+	for i := range msg.DataFlowSet {
+		fillDataFlowSet(&(msg.DataFlowSet[i]))
+		count += uint16(len(msg.DataFlowSet[i].Records))
+	}
+
+	msg.Header.Count = count
+}
+
+func fillTemplateFlowSet(flowSet *TemplateFlowSet) {
+	length := uint16(4) //flowset head
+
+	for _, tpl := range flowSet.Templates {
+		length += 4 //template head
+		length += uint16(len(tpl.Fields)) * 4
+	}
+	flowSet.Header.Length = length
+}
+
+func fillOptionsTemplateFlowSet(flowSet *OptionsTemplateFlowSet) {
+	length := uint16(4) //flowset head
+
+	for _, tpl := range flowSet.OptionTemplates {
+		length += 6 //options template head
+		length += tpl.ScopeLength + tpl.OptionLength
+	}
+	if length%4 != 0 {
+		flowSet.padding = int(4 - length%4)
+		length += 4 - length%4
+	}
+	flowSet.Header.Length = length
+}
+
+func fillDataFlowSet(flowSet *DataFlowSet) {
+	length := uint16(4) //flowset head
+
+	for _, record := range flowSet.Records {
+		for _, field := range record {
+			length += uint16(len(field.Value))
+		}
+	}
+	if length%4 != 0 {
+		flowSet.padding = int(4 - length%4)
+		length += 4 - length%4
+	}
+	flowSet.Header.Length = length
+}
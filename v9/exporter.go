@@ -0,0 +1,47 @@
+package v9
+
+import "nflow-generator/legacy"
+
+//Exporter keeps the state of a simulated netflow v9 exporter: its source id,
+//its sampling and the sequence counter of the packets it sent
+type Exporter struct {
+	SourceID          uint32
+	SamplingAlgorithm uint8  // legacy sampling mode, SAMPLING_NONE when unsampled
+	SamplingInterval  uint16 // one packet out of SamplingInterval
+	seqNum            uint32
+}
+
+func NewExporter(sourceID uint32, samplingAlgorithm uint8, samplingInterval uint16) *Exporter {
+	return &Exporter{
+		SourceID:          sourceID,
+		SamplingAlgorithm: samplingAlgorithm,
+		SamplingInterval:  samplingInterval,
+	}
+}
+
+//Encode a message of the exporter with its source id, its sampling options
+//and the next sequence number, counting the packets sent modulo 2^32 -
+//RFC3954#section-5.1
+func (e *Exporter) Encode(msg Message) []byte {
+	msg.Header.SourceID = e.SourceID
+	msg.DataFlowSet = e.samplingOptions(msg.DataFlowSet)
+	e.seqNum++
+	return Encode(msg, e.seqNum)
+}
+
+//replace the options data of sets by the sampling of the exporter, scoped
+//to its source id. Unsampled exporters announce one packet out of one.
+func (e *Exporter) samplingOptions(sets []DataFlowSet) []DataFlowSet {
+	algorithm, interval := legacy.SAMPLING_DETERMINISTIC, uint32(1)
+	if e.SamplingAlgorithm != legacy.SAMPLING_NONE {
+		algorithm, interval = e.SamplingAlgorithm, uint32(e.SamplingInterval)
+	}
+	updated := make([]DataFlowSet, len(sets))
+	for i, set := range sets {
+		if set.Header.ID == OptionsTemplateID {
+			set.Records = [][]DataField{CreateSamplingOptionsRecord(e.SourceID, interval, algorithm)}
+		}
+		updated[i] = set
+	}
+	return updated
+}
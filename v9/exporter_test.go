@@ -0,0 +1,45 @@
+package v9
+
+import (
+	"bytes"
+	"math/rand"
+	"nflow-generator/legacy"
+	"testing"
+)
+
+func TestEncodeSamplingOptions(t *testing.T) {
+	tests := []struct {
+		name              string
+		algorithm         uint8
+		interval          uint16
+		wantAlgorithm     uint8
+		wantIntervalBytes []byte
+	}{
+		{"unsampled", legacy.SAMPLING_NONE, 0, legacy.SAMPLING_DETERMINISTIC, []byte{0, 0, 0, 1}},
+		{"random", legacy.SAMPLING_RANDOM, 100, legacy.SAMPLING_RANDOM, []byte{0, 0, 0, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewExporter(42, tt.algorithm, tt.interval)
+			msg := GenerateNetflow(rand.New(rand.NewSource(1)), 16, nil, false)
+			sets := e.samplingOptions(msg.DataFlowSet)
+			if sets[0].Header.ID != OptionsTemplateID || len(sets[0].Records) != 1 {
+				t.Fatalf("first flowset %+v, want the sampling options data", sets[0])
+			}
+			record := sets[0].Records[0]
+			if scope := record[0].Value; !bytes.Equal(scope, []byte{0, 0, 0, 42}) {
+				t.Errorf("scope system %v, want the source id 42", scope)
+			}
+			if interval := record[1].Value; !bytes.Equal(interval, tt.wantIntervalBytes) {
+				t.Errorf("sampling interval %v, want %v", interval, tt.wantIntervalBytes)
+			}
+			if algorithm := record[2].Value; !bytes.Equal(algorithm, []byte{tt.wantAlgorithm}) {
+				t.Errorf("sampling algorithm %v, want %d", algorithm, tt.wantAlgorithm)
+			}
+			// the generated message is left untouched
+			if scope := msg.DataFlowSet[0].Records[0][0].Value; !bytes.Equal(scope, []byte{0, 0, 0, 0}) {
+				t.Errorf("generated message scope changed to %v", scope)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package v9
+
+const (
+	VERSION                     = uint16(9) // 2 byte
+	TEMPLATE_FLOWSET_ID         = uint16(0)
+	OPTIONS_TEMPLATE_FLOWSET_ID = uint16(1)
+	PADDING                     = uint8(0) // 1 byte
+)
+
+type Message struct {
+	Header                 PacketHeader             `json:"header"`
+	TemplateFlowSet        []TemplateFlowSet        `json:"templateFlowSet"`
+	OptionsTemplateFlowSet []OptionsTemplateFlowSet `json:"optionsTemplateFlowSet"`
+	DataFlowSet            []DataFlowSet            `json:"dataFlowSet"`
+}
+
+//NetFlow v9 packet header - RFC3954#section-5.1
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|       Version Number          |            Count              |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                           sysUpTime                           |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                           UNIX Secs                           |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                       Sequence Number                         |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|                        Source ID                              |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type PacketHeader struct {
+	Version    uint16 `json:"version"`     // Version of Flow Record format exported in this packet
+	Count      uint16 `json:"count"`       // Total number of records (template, options and data) in the packet
+	SysUptime  uint32 `json:"sys_uptime"`  // Time in milliseconds since this device was first booted
+	UnixSecs   uint32 `json:"unix_secs"`   // Time in seconds since 0000 UTC 1970, at which the packet leaves the Exporter
+	SequenceNo uint32 `json:"sequence_no"` // Incremental sequence counter of all export packets sent by the Exporter
+	SourceID   uint32 `json:"source_id"`   // A 32-bit value that identifies the Exporter Observation Domain
+}
+
+//flowset header
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|       FlowSet ID              |          Length               |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type FlowSetHeader struct {
+	ID     uint16 `json:"id"`
+	Length uint16 `json:"length"`
+}
+
+type TemplateFlowSet struct {
+	Header    FlowSetHeader    `json:"header"`
+	Templates []TemplateRecord `json:"templates"`
+}
+
+//template record header
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|      Template ID (> 255)      |         Field Count           |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type TemplateRecord struct {
+	ID         uint16           `json:"id"`
+	FieldCount uint16           `json:"field_count"`
+	Fields     []FieldSpecifier `json:"fields"`
+}
+
+//Field Specifier, v9 field types share their ids with IANA IPFIX elements
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|        Field Type             |        Field Length           |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type FieldSpecifier struct {
+	Type   uint16 `json:"type"`
+	Length uint16 `json:"length"`
+}
+
+type OptionsTemplateFlowSet struct {
+	Header          FlowSetHeader           `json:"header"`
+	OptionTemplates []OptionsTemplateRecord `json:"options"`
+	padding         int                     //byte count
+}
+
+//options template record head
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|         Template ID (> 255)   |      Option Scope Length      |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|        Option Length          |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type OptionsTemplateRecord struct {
+	ID           uint16           `json:"id"`
+	ScopeLength  uint16           `json:"scope_length"`  // length in bytes of the scope field specifiers
+	OptionLength uint16           `json:"option_length"` // length in bytes of the option field specifiers
+	ScopeFields  []FieldSpecifier `json:"scope_fields"`
+	OptionFields []FieldSpecifier `json:"option_fields"`
+}
+
+type DataField struct {
+	FieldType uint16 `json:"field_type"`
+	Value     []byte `json:"value"`
+}
+
+//data flowset, containing data records or options data records
+//0                   1                   2                   3
+//0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|   FlowSet ID = Template ID    |          Length               |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|   Record 1 - Field Value 1    |   Record 1 - Field Value 2    |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|   Record 2 - Field Value 1    |   Record 2 - Field Value 2    |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//|              ...              |      Padding (optional)       |
+//+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+type DataFlowSet struct {
+	Header  FlowSetHeader `json:"header"`
+	Records [][]DataField `json:"records"`
+	padding int           //byte count
+}
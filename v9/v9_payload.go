@@ -0,0 +1,358 @@
+package v9
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"nflow-generator/ipfix"
+	"nflow-generator/legacy"
+	"nflow-generator/scenario"
+)
+
+const (
+	DataTemplateID     = uint16(256)
+	OptionsTemplateID  = uint16(257)
+	DataIPv6TemplateID = uint16(258)
+
+	// system scope field type of options templates - RFC3954#section-6.1
+	ScopeSystem = uint16(1)
+)
+
+//check ipfix rfc5102_model file for ids, v9 field types 1-127 share them
+func GetIDs() []uint16 {
+	return []uint16{
+		8,  //sourceIPv4Address
+		12, //destinationIPv4Address
+		15, //ipNextHopIPv4Address
+		10, //ingressInterface
+		14, //egressInterface
+		2,  //packetDeltaCount
+		1,  //octetDeltaCount
+		22, //flowStartSysUpTime
+		21, //flowEndSysUpTime
+		7,  //sourceTransportPort
+		11, //destinationTransportPort
+		6,  //tcpControlBits
+		4,  //protocolIdentifier
+		5,  //ipClassOfService
+		16, //bgpSourceAsNumber
+		17, //bgpDestinationAsNumber
+		9,  //sourceIPv4PrefixLength
+		13, //destinationIPv4PrefixLength
+	}
+}
+
+//same fields as GetIDs using IPv6 addresses and prefixes
+func GetIPv6IDs() []uint16 {
+	return []uint16{
+		27, //sourceIPv6Address
+		28, //destinationIPv6Address
+		62, //ipNextHopIPv6Address
+		10, //ingressInterface
+		14, //egressInterface
+		2,  //packetDeltaCount
+		1,  //octetDeltaCount
+		22, //flowStartSysUpTime
+		21, //flowEndSysUpTime
+		7,  //sourceTransportPort
+		11, //destinationTransportPort
+		6,  //tcpControlBits
+		4,  //protocolIdentifier
+		5,  //ipClassOfService
+		16, //bgpSourceAsNumber
+		17, //bgpDestinationAsNumber
+		29, //sourceIPv6PrefixLength
+		30, //destinationIPv6PrefixLength
+	}
+}
+
+//option fields describing the exporter sampling configuration
+func GetOptionIDs() []uint16 {
+	return []uint16{
+		34, //samplingInterval
+		35, //samplingAlgorithm
+	}
+}
+
+//Generate a v9 packet holding the template, the options template,
+//the sampling options data and recordCount data records.
+//IPv6 ips are exported using a dedicated template.
+func GenerateNetflow(r *rand.Rand, recordCount int, ips []string, fi bool) *Message {
+	templateID, ids := templateFor(ips)
+	legacyIPs := ips
+	if ipfix.IsIPv6(ips) {
+		legacyIPs = nil
+	}
+	data := legacy.GenerateNetflow(r, recordCount, legacyIPs, fi)
+
+	var records [][]DataField
+	for _, record := range data.Records {
+		var src, dst, nextHop net.IP
+		if ipfix.IsIPv6(ips) {
+			src = net.ParseIP(ips[r.Int()%len(ips)])
+			dst = net.ParseIP(ips[r.Int()%len(ips)])
+			nextHop = net.ParseIP(ips[r.Int()%len(ips)])
+		}
+		records = append(records, CreateDataRecord(ids, record, src, dst, nextHop))
+	}
+
+	return createMessage(data.Header, templateID, ids, records)
+}
+
+//Generate a v9 packet like GenerateNetflow with records drawn from a scenario
+func GenerateScenarioNetflow(r *rand.Rand, recordCount int, s *scenario.Scenario, ips []string, fi bool) *Message {
+	templateID, ids := templateFor(ips)
+	header := legacy.CreateNFlowHeader(recordCount)
+
+	var records [][]DataField
+	for i := 0; i < recordCount; i++ {
+		f := s.Next(r, ips)
+		record := legacy.CreateScenarioFlow(r, f)
+		legacy.SetFalseIndex(&record, fi)
+		records = append(records, CreateDataRecord(ids, record, f.SrcIP, f.DstIP, nil))
+	}
+
+	return createMessage(header, templateID, ids, records)
+}
+
+//data template matching the ip version of ips
+func templateFor(ips []string) (uint16, []uint16) {
+	if ipfix.IsIPv6(ips) {
+		return DataIPv6TemplateID, GetIPv6IDs()
+	}
+	return DataTemplateID, GetIDs()
+}
+
+func createMessage(header legacy.NetflowHeader, templateID uint16, ids []uint16, records [][]DataField) *Message {
+	var fields []FieldSpecifier
+	for _, id := range ids {
+		fields = append(fields, FieldSpecifier{
+			Type:   id,
+			Length: ipfix.FieldLength(id),
+		})
+	}
+
+	return &Message{
+		Header: PacketHeader{
+			Version:    VERSION,
+			Count:      0,
+			SysUptime:  header.SysUptime,
+			UnixSecs:   header.UnixSec,
+			SequenceNo: 0,
+			SourceID:   0,
+		},
+		TemplateFlowSet: []TemplateFlowSet{
+			{
+				Header: FlowSetHeader{
+					ID:     TEMPLATE_FLOWSET_ID,
+					Length: 0,
+				},
+				Templates: []TemplateRecord{{
+					ID:         templateID,
+					FieldCount: uint16(len(fields)),
+					Fields:     fields,
+				}},
+			},
+		},
+		OptionsTemplateFlowSet: []OptionsTemplateFlowSet{
+			{
+				Header: FlowSetHeader{
+					ID:     OPTIONS_TEMPLATE_FLOWSET_ID,
+					Length: 0,
+				},
+				OptionTemplates: []OptionsTemplateRecord{CreateSamplingOptionsTemplate()},
+			},
+		},
+		DataFlowSet: []DataFlowSet{
+			{
+				Header: FlowSetHeader{
+					ID:     OptionsTemplateID,
+					Length: 0,
+				},
+				Records: [][]DataField{CreateSamplingOptionsRecord(0, 1, 1)},
+			},
+			{
+				Header: FlowSetHeader{
+					ID:     templateID,
+					Length: 0,
+				},
+				Records: records,
+			},
+		},
+	}
+}
+
+//FlowStats returns the number of data records, options excluded,
+//and the octets they describe
+func (msg Message) FlowStats() (int, uint64) {
+	records := 0
+	octets := uint64(0)
+	for _, flowSet := range msg.DataFlowSet {
+		if flowSet.Header.ID == OptionsTemplateID {
+			continue
+		}
+		records += len(flowSet.Records)
+		for _, record := range flowSet.Records {
+			for _, field := range record {
+				if field.FieldType == 1 {
+					octets += binary.BigEndian.Uint64(field.Value)
+				}
+			}
+		}
+	}
+	return records, octets
+}
+
+//FlowKey returns the values of the 5-tuple fields of a data record
+func FlowKey(record []DataField) []byte {
+	var key []byte
+	for _, field := range record {
+		switch field.FieldType {
+		case 4, 7, 8, 11, 12, 27, 28:
+			key = append(key, field.Value...)
+		}
+	}
+	return key
+}
+
+//Split distributes the data records of a message in parts messages,
+//templates and options data are copied in every part
+func (msg Message) Split(parts int, bucket func(record []DataField) int) []Message {
+	split := make([]Message, parts)
+	for i := range split {
+		split[i] = Message{
+			Header:                 msg.Header,
+			TemplateFlowSet:        msg.TemplateFlowSet,
+			OptionsTemplateFlowSet: msg.OptionsTemplateFlowSet,
+		}
+	}
+	for _, flowSet := range msg.DataFlowSet {
+		if flowSet.Header.ID == OptionsTemplateID {
+			for i := range split {
+				split[i].DataFlowSet = append(split[i].DataFlowSet, flowSet)
+			}
+			continue
+		}
+		sets := make([]DataFlowSet, parts)
+		for _, record := range flowSet.Records {
+			i := bucket(record)
+			sets[i].Records = append(sets[i].Records, record)
+		}
+		for i := range split {
+			if len(sets[i].Records) > 0 {
+				sets[i].Header = flowSet.Header
+				split[i].DataFlowSet = append(split[i].DataFlowSet, sets[i])
+			}
+		}
+	}
+	return split
+}
+
+//options template scoped to the whole system
+func CreateSamplingOptionsTemplate() OptionsTemplateRecord {
+	scope := []FieldSpecifier{{Type: ScopeSystem, Length: 4}}
+	var options []FieldSpecifier
+	for _, id := range GetOptionIDs() {
+		options = append(options, FieldSpecifier{
+			Type:   id,
+			Length: ipfix.FieldLength(id),
+		})
+	}
+	return OptionsTemplateRecord{
+		ID:           OptionsTemplateID,
+		ScopeLength:  uint16(len(scope) * 4),
+		OptionLength: uint16(len(options) * 4),
+		ScopeFields:  scope,
+		OptionFields: options,
+	}
+}
+
+func CreateSamplingOptionsRecord(sourceID uint32, interval uint32, algorithm uint8) []DataField {
+	return []DataField{
+		{FieldType: ScopeSystem, Value: toBytes(uint64(sourceID), 4)},
+		{FieldType: 34, Value: toBytes(uint64(interval), ipfix.FieldLength(34))},
+		{FieldType: 35, Value: toBytes(uint64(algorithm), ipfix.FieldLength(35))},
+	}
+}
+
+//Convert a legacy v5 record into a v9 data record following ids order,
+//IPv6 addresses are not part of the v5 record and given aside
+func CreateDataRecord(ids []uint16, r legacy.NetflowPayload, src, dst, nextHop net.IP) []DataField {
+	var dfs []DataField
+	for _, id := range ids {
+		var value []byte
+		switch id {
+		case 27:
+			value = toIPv6(src)
+		case 28:
+			value = toIPv6(dst)
+		case 62:
+			value = toIPv6(nextHop)
+		default:
+			value = toBytes(recordValue(id, r), ipfix.FieldLength(id))
+		}
+		dfs = append(dfs, DataField{
+			FieldType: id,
+			Value:     value,
+		})
+	}
+	return dfs
+}
+
+func recordValue(id uint16, r legacy.NetflowPayload) uint64 {
+	switch id {
+	case 1:
+		return uint64(r.NumOctets)
+	case 2:
+		return uint64(r.NumPackets)
+	case 4:
+		return uint64(r.IpProtocol)
+	case 5:
+		return uint64(r.IpTos)
+	case 6:
+		return uint64(r.TcpFlags)
+	case 7:
+		return uint64(r.SrcPort)
+	case 8:
+		return uint64(r.SrcIP)
+	case 9, 29:
+		return uint64(r.SrcPrefixMask)
+	case 10:
+		return uint64(r.SnmpInIndex)
+	case 11:
+		return uint64(r.DstPort)
+	case 12:
+		return uint64(r.DstIP)
+	case 13, 30:
+		return uint64(r.DstPrefixMask)
+	case 14:
+		return uint64(r.SnmpOutIndex)
+	case 15:
+		return uint64(r.NextHopIP)
+	case 16:
+		return uint64(r.SrcAsNumber)
+	case 17:
+		return uint64(r.DstAsNumber)
+	case 21:
+		return uint64(r.SysUptimeEnd)
+	case 22:
+		return uint64(r.SysUptimeStart)
+	default:
+		return 0
+	}
+}
+
+//16 bytes address, unspecified if not set
+func toIPv6(ip net.IP) []byte {
+	if ip == nil {
+		return net.IPv6unspecified
+	}
+	return ip.To16()
+}
+
+//big endian encoding of n on length bytes
+func toBytes(n uint64, length uint16) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b[8-length:]
+}